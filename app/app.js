@@ -170,12 +170,13 @@ async function uploadVideoFile(videoID) {
       },
       body: formData,
     });
+    const data = await res.json();
     if (!res.ok) {
-      const data = await res.json();
       throw new Error(`Failed to upload video file. Error: ${data.error}`);
     }
 
-    console.log('Video uploaded!');
+    console.log('Video uploaded, processing...');
+    await waitForJob(data.job_id);
     await getVideo(videoID);
   } catch (error) {
     alert(`Error: ${error.message}`);
@@ -184,35 +185,68 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(false, uploadBtnSelector);
 }
 
-const videoStateHandler = createVideoStateHandler();
-
-async function getVideos() {
-  try {
-    const res = await fetch('/api/videos', {
+async function waitForJob(jobID) {
+  while (true) {
+    const res = await fetch(`/api/jobs/${jobID}`, {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
       },
     });
+    const job = await res.json();
     if (!res.ok) {
-      const data = await res.json();
-      throw new Error(`Failed to get videos. Error: ${data.error}`);
+      throw new Error(`Failed to get processing job. Error: ${job.error}`);
+    }
+    if (job.status === 'succeeded') {
+      return;
+    }
+    if (job.status === 'dead') {
+      throw new Error(`Failed to process video. Error: ${job.last_error}`);
     }
+    await new Promise((resolve) => setTimeout(resolve, 2000));
+  }
+}
 
-    const videos = await res.json();
+const videoStateHandler = createVideoStateHandler();
+
+async function getVideos() {
+  try {
     const videoList = document.getElementById('video-list');
     videoList.innerHTML = '';
-    for (const video of videos) {
-      const listItem = document.createElement('li');
-      listItem.textContent = video.title;
-      listItem.onclick = () => videoStateHandler(video.id);
-      videoList.appendChild(listItem);
+
+    // the list is paged, follow the next links until the last page
+    let url = '/api/videos';
+    while (url) {
+      const res = await fetch(url, {
+        method: 'GET',
+        headers: {
+          Authorization: `Bearer ${localStorage.getItem('token')}`,
+        },
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(`Failed to get videos. Error: ${data.error}`);
+      }
+
+      const videos = await res.json();
+      for (const video of videos) {
+        const listItem = document.createElement('li');
+        listItem.textContent = video.title;
+        listItem.onclick = () => videoStateHandler(video.id);
+        videoList.appendChild(listItem);
+      }
+      url = nextPageURL(res.headers.get('Link'));
     }
   } catch (error) {
     alert(`Error: ${error.message}`);
   }
 }
 
+function nextPageURL(linkHeader) {
+  const match = /<([^>]+)>;\s*rel="next"/.exec(linkHeader || '');
+  return match ? match[1] : null;
+}
+
 function createVideoStateHandler() {
   let currentVideoID = null;
 
@@ -262,6 +296,8 @@ function viewVideo(video) {
   } else {
     thumbnailImg.style.display = 'block';
     thumbnailImg.src = video.thumbnail_url;
+    const srcset = video.thumbnail_srcset || {};
+    thumbnailImg.srcset = srcset['image/jpeg'] || srcset['image/png'] || '';
   }
 
   const videoPlayer = document.getElementById('video-player');
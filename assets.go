@@ -1,35 +1,22 @@
 package main
 
 import (
-	"bytes"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
 )
 
-func (cfg apiConfig) ensureAssetsDir() error {
-	if _, err := os.Stat(cfg.assetsRoot); os.IsNotExist(err) {
-		return os.Mkdir(cfg.assetsRoot, 0755)
-	}
-	return nil
-}
-
 func getAssetPath(videoID string, mediaType string) string {
 	ext := mediaTypeToExt(mediaType)
 	return fmt.Sprintf("%s%s", videoID, ext)
 }
 
-func (cfg apiConfig) getAssetDiskPath(assetPath string) string {
-	return filepath.Join(cfg.assetsRoot, assetPath)
-}
-
-func (cfg apiConfig) getAssetURL(assetPath string) string {
-	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, assetPath)
+func (cfg apiConfig) getAssetsBaseURL() string {
+	return fmt.Sprintf("http://localhost:%s/assets", cfg.port)
 }
 
 func mediaTypeToExt(mediaType string) string {
@@ -46,35 +33,60 @@ func getRandomAssetsName(numByte int) string {
 	return base64.RawURLEncoding.EncodeToString(bt)
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
-	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
-
-	var bufer bytes.Buffer
-	cmd.Stdout = &bufer
-
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("ffprobe: %v", err)
+// videoOrientations are the classes getVideoOrientation returns
+var videoOrientations = []string{LandscapePrefix, PortraitPrefix, SquarePrefix, UltrawidePrefix, OtherPrefix}
+
+// getVideoOrientation classifies a video by the shape it is displayed in,
+// taking rotation and non-square pixels into account. Files without usable
+// dimensions are classified as other.
+func getVideoOrientation(probe videoProbe) string {
+	width := float64(probe.Width) * probe.SampleAspectRatio
+	height := float64(probe.Height)
+	if width <= 0 || height <= 0 {
+		return OtherPrefix
+	}
+	if probe.Rotation == 90 || probe.Rotation == 270 {
+		width, height = height, width
 	}
 
-	type aspectRatio struct {
-		Streams []struct {
-			AR string `json:"display_aspect_ratio"`
-		} `json:"streams"`
+	ratio := width / height
+	switch {
+	case math.Abs(ratio-1) <= SquareTolerance:
+		return SquarePrefix
+	case ratio >= UltrawideMinRatio:
+		return UltrawidePrefix
+	case ratio > 1:
+		return LandscapePrefix
+	default:
+		return PortraitPrefix
 	}
-	var aspRat aspectRatio
+}
+
+// convertVideoToMP4 turns an upload in another container into a fast start
+// MP4. Streams that are already H.264 and AAC are copied, anything else is
+// transcoded.
+func convertVideoToMP4(filePath string, probe videoProbe) (string, error) {
+	newPath := filePath + ".processing"
 
-	if err := json.Unmarshal(bufer.Bytes(), &aspRat); err != nil {
-		return "", fmt.Errorf("unmarshal ffprobe json: %w", err)
+	args := []string{"-i", filePath, "-map", "0:v:0", "-map", "0:a:0?"}
+	if probe.VideoCodec == "h264" {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p")
 	}
+	if !probe.HasAudio || probe.AudioCodec == "aac" {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", "aac", "-b:a", "128k")
+	}
+	args = append(args, "-movflags", "faststart", "-f", "mp4", "-y", newPath)
 
-	switch aspRat.Streams[0].AR {
-	case AspectRatio16x9:
-		return LandscapePrefix, nil
-	case AspectRatio9x16:
-		return PortraitPrefix, nil
-	default:
-		return OtherPrefix, nil
+	if err := runFFmpeg(args...); err != nil {
+		os.Remove(newPath)
+		return "", err
 	}
+
+	return newPath, nil
 }
 
 func processVideoForFastStart(filePath string) (string, error) {
@@ -83,6 +95,7 @@ func processVideoForFastStart(filePath string) (string, error) {
 	cmd := exec.Command("ffmpeg", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", newPath)
 
 	if err := cmd.Run(); err != nil {
+		os.Remove(newPath)
 		return "", fmt.Errorf("ffprobe: %v", err)
 	}
 
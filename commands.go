@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// runCommand runs a one-shot maintenance command instead of the server,
+// e.g. `go run . gc -dry-run`
+func (cfg *apiConfig) runCommand(args []string) error {
+	switch args[0] {
+	case "gc":
+		return cfg.commandGC(args[1:])
+	case "migrate":
+		return cfg.commandMigrate(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func (cfg *apiConfig) commandGC(args []string) error {
+	opts := cfg.gcOptions
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "only report orphaned objects")
+	flags.DurationVar(&opts.GracePeriod, "grace", opts.GracePeriod, "ignore objects modified more recently than this")
+	flags.StringVar(&opts.Mode, "mode", opts.Mode, "what to do with orphans: delete or quarantine")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if opts.Mode != GCModeDelete && opts.Mode != GCModeQuarantine {
+		return fmt.Errorf("invalid gc mode %q", opts.Mode)
+	}
+
+	report, err := cfg.collectGarbage(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	fmt.Println(report)
+	return nil
+}
+
+// commandMigrate applies, reverts or lists schema migrations:
+// `migrate up`, `migrate down -steps 1` or `migrate status`
+func (cfg *apiConfig) commandMigrate(args []string) error {
+	migrator, ok := cfg.db.(database.Migrator)
+	if !ok {
+		return fmt.Errorf("the configured database has no schema to migrate")
+	}
+
+	action := "up"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	switch action {
+	case "up":
+		applied, err := migrator.MigrateUp()
+		for _, m := range applied {
+			fmt.Printf("applied %d %s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	case "down":
+		flags := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		steps := flags.Int("steps", 1, "number of migrations to revert")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		reverted, err := migrator.MigrateDown(*steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %d %s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.MigrationStatuses()
+		if err != nil {
+			return err
+		}
+		for _, m := range statuses {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = "applied " + m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d %-40s %s\n", m.Version, m.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate action %q, expected up, down or status", action)
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
+import "time"
+
 // HTTP status codes
 const (
 	StatusOK                  = 200
 	StatusCreated             = 201
+	StatusAccepted            = 202
 	StatusNoContent           = 204
 	StatusBadRequest          = 400
 	StatusUnauthorized        = 401
 	StatusForbidden           = 403
 	StatusNotFound            = 404
+	StatusConflict            = 409
 	StatusInternalServerError = 500
 	StatusBadGateway          = 502
 )
@@ -17,16 +21,39 @@ const (
 const (
 	MaxVideoUploadSize = 1 << 30  // 1 GB
 	MaxThumbnailSize   = 10 << 20 // 10 MB
+	MaxThumbnailPixels = 16_000_000
+)
+
+// Video listing page sizes
+const (
+	DefaultVideoPageSize = 50
+	MaxVideoPageSize     = 200
 )
 
+// ThumbnailJPEGQuality is the quality JPEG thumbnail variants are encoded at
+const ThumbnailJPEGQuality = 85
+
 // Time durations
-const ()
+const (
+	// AbandonedUploadGrace is how long after its presigned URLs expire an
+	// upload that was never completed stops counting as in progress
+	AbandonedUploadGrace = 15 * time.Minute
+)
+
+// tus resumable upload protocol
+const (
+	TusVersion    = "1.0.0"
+	TusExtensions = "creation,termination"
+	TusMediaType  = "application/offset+octet-stream"
+)
 
 // Media types
 const (
 	VideoMP4Type  = "video/mp4"
 	ImageJPEGType = "image/jpeg"
 	ImagePNGType  = "image/png"
+	ImageWebPType = "image/webp"
+	ImageAVIFType = "image/avif"
 )
 
 // File extensions
@@ -36,15 +63,67 @@ const (
 	PNGExtension  = ".png"
 )
 
+// AutoThumbnailBatchFrames is how many frames ffmpeg's thumbnail filter
+// compares when picking the best frame
+const AutoThumbnailBatchFrames = 300
+
+// OriginalName is the name an archived upload is stored under, next to the
+// MP4 converted from it
+const OriginalName = "original"
+
+// Scrubbing previews
+const (
+	PreviewDir        = "previews"
+	PreviewSpriteName = "sprite.jpg"
+	PreviewVTTName    = "thumbnails.vtt"
+	PreviewColumns    = 10
+	PreviewMaxFrames  = 100
+)
+
+// Adaptive streaming output
+const (
+	StreamFormatHLS      = "hls"
+	StreamFormatDASH     = "dash"
+	StreamSegmentSeconds = 6
+	HLSDir               = "hls"
+	HLSMasterPlaylist    = "master.m3u8"
+	DASHDir              = "dash"
+	DASHManifest         = "manifest.mpd"
+)
+
+// Storage backends
+const (
+	StorageBackendS3     = "s3"
+	StorageBackendLocal  = "local"
+	StorageBackendMemory = "memory"
+)
+
+// Background job types
+const (
+	JobTypeProcessVideo = "process_video"
+)
+
+// Blob stores referenced by pending asset deletions
+const (
+	AssetStoreVideo     = "video"
+	AssetStoreThumbnail = "thumbnail"
+)
+
 // S3 key prefixes
 const (
 	LandscapePrefix = "landscape"
 	PortraitPrefix  = "portrait"
+	SquarePrefix    = "square"
+	UltrawidePrefix = "ultrawide"
 	OtherPrefix     = "other"
+	UploadsPrefix   = "uploads"
+	StreamsPrefix   = "streams"
 )
 
-// Aspect ratios
+// Orientation bands, as display width divided by display height. Ratios
+// within SquareTolerance of 1 are square, landscape ratios from
+// UltrawideMinRatio up are ultrawide (21:9 is about 2.33).
 const (
-	AspectRatio16x9 = "16:9"
-	AspectRatio9x16 = "9:16"
+	SquareTolerance   = 0.1
+	UltrawideMinRatio = 2.1
 )
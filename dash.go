@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// packageDASH splits encoded renditions into fragmented MP4 segments and
+// writes the MPD manifest to outDir. Video and audio representations go in
+// separate adaptation sets. It returns the path of the manifest relative to
+// outDir.
+func packageDASH(renditions []encodedRendition, outDir string) (string, error) {
+	args := []string{}
+	for _, r := range renditions {
+		args = append(args, "-i", r.Path)
+	}
+
+	adaptationSets := "id=0,streams=v"
+	for i := range renditions {
+		args = append(args, "-map", fmt.Sprintf("%d:v:0", i))
+	}
+	if renditions[0].HasAudio {
+		for i := range renditions {
+			args = append(args, "-map", fmt.Sprintf("%d:a:0", i))
+		}
+		adaptationSets += " id=1,streams=a"
+	}
+
+	args = append(args,
+		"-c", "copy",
+		"-f", "dash",
+		"-seg_duration", fmt.Sprint(StreamSegmentSeconds),
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-adaptation_sets", adaptationSets,
+		"-init_seg_name", "init-$RepresentationID$.m4s",
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		filepath.Join(outDir, DASHManifest),
+	)
+
+	if err := runFFmpeg(args...); err != nil {
+		return "", err
+	}
+	return DASHManifest, nil
+}
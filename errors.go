@@ -1,6 +1,11 @@
 package main
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
 
 // Custom error types for better error handling
 type ValidationError struct {
@@ -29,13 +34,13 @@ func (e FileProcessingError) Error() string {
 	return fmt.Sprintf("file processing error during '%s': %s", e.Operation, e.Message)
 }
 
-type S3Error struct {
+type StorageError struct {
 	Operation string
 	Message   string
 }
 
-func (e S3Error) Error() string {
-	return fmt.Sprintf("S3 error during '%s': %s", e.Operation, e.Message)
+func (e StorageError) Error() string {
+	return fmt.Sprintf("storage error during '%s': %s", e.Operation, e.Message)
 }
 
 // Helper functions to create errors
@@ -51,6 +56,18 @@ func NewFileProcessingError(operation, message string) FileProcessingError {
 	return FileProcessingError{Operation: operation, Message: message}
 }
 
-func NewS3Error(operation, message string) S3Error {
-	return S3Error{Operation: operation, Message: message}
+func NewStorageError(operation, message string) StorageError {
+	return StorageError{Operation: operation, Message: message}
+}
+
+// databaseErrorStatus maps a database error onto the status to respond with
+func databaseErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		return StatusNotFound
+	case errors.Is(err, database.ErrConflict):
+		return StatusConflict
+	default:
+		return StatusInternalServerError
+	}
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// videoProbe is the technical metadata of a media file as read by ffprobe,
+// the video fields describe the first video stream and the audio fields the
+// first audio stream
+type videoProbe struct {
+	Width             int
+	Height            int
+	SampleAspectRatio float64 // pixel width / pixel height, 1 for square pixels
+	Rotation          int     // degrees
+	VideoCodec        string
+	FrameRate         float64
+	HasAudio          bool
+	AudioCodec        string
+	AudioChannels     int
+	Duration          float64 // seconds
+	Bitrate           int64   // bit/s
+	Format            string
+}
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType         string            `json:"codec_type"`
+		CodecName         string            `json:"codec_name"`
+		Width             int               `json:"width"`
+		Height            int               `json:"height"`
+		SampleAspectRatio string            `json:"sample_aspect_ratio"`
+		AvgFrameRate      string            `json:"avg_frame_rate"`
+		RFrameRate        string            `json:"r_frame_rate"`
+		Channels          int               `json:"channels"`
+		Tags              map[string]string `json:"tags"`
+		SideDataList      []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+// errNoVideoStream is returned by probeVideo for audio-only files and files
+// without any streams
+var errNoVideoStream = errors.New("no video stream found")
+
+// probeVideo runs ffprobe on filePath. Files without a video stream are
+// rejected with errNoVideoStream.
+func probeVideo(filePath string) (videoProbe, error) {
+	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", "-show_format", filePath)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return videoProbe{}, fmt.Errorf("ffprobe: %v", err)
+	}
+
+	var probe ffprobeOutput
+	if err := json.Unmarshal(out.Bytes(), &probe); err != nil {
+		return videoProbe{}, fmt.Errorf("unmarshal ffprobe json: %w", err)
+	}
+
+	result := videoProbe{}
+	hasVideo := false
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			if hasVideo {
+				continue
+			}
+			hasVideo = true
+			result.Width = stream.Width
+			result.Height = stream.Height
+			result.SampleAspectRatio = parseRatio(stream.SampleAspectRatio, ":")
+			if result.SampleAspectRatio <= 0 {
+				// "0:1" means unknown, treat the pixels as square
+				result.SampleAspectRatio = 1
+			}
+			result.VideoCodec = stream.CodecName
+			result.FrameRate = parseFrameRate(stream.AvgFrameRate)
+			if result.FrameRate == 0 {
+				result.FrameRate = parseFrameRate(stream.RFrameRate)
+			}
+			// newer ffprobe reports rotation in the display matrix side
+			// data, older versions in the rotate tag
+			if rotate, err := strconv.Atoi(stream.Tags["rotate"]); err == nil {
+				result.Rotation = rotate
+			}
+			for _, sideData := range stream.SideDataList {
+				if sideData.Rotation != 0 {
+					result.Rotation = int(-sideData.Rotation)
+				}
+			}
+			result.Rotation = ((result.Rotation % 360) + 360) % 360
+		case "audio":
+			if result.HasAudio {
+				continue
+			}
+			result.HasAudio = true
+			result.AudioCodec = stream.CodecName
+			result.AudioChannels = stream.Channels
+		}
+	}
+	if !hasVideo {
+		return videoProbe{}, errNoVideoStream
+	}
+
+	result.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	result.Bitrate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+	result.Format = probe.Format.FormatName
+	return result, nil
+}
+
+// parseFrameRate parses ffprobe rates such as "30000/1001"
+func parseFrameRate(rate string) float64 {
+	return math.Round(parseRatio(rate, "/")*1000) / 1000
+}
+
+// parseRatio parses "a<sep>b" as a/b or a plain number, invalid values
+// are 0
+func parseRatio(ratio, sep string) float64 {
+	num, den, ok := strings.Cut(ratio, sep)
+	if !ok {
+		f, _ := strconv.ParseFloat(ratio, 64)
+		return f
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// metadata converts the probe to the columns stored on the video, unknown
+// values are left nil
+func (p videoProbe) metadata() database.MediaMetadata {
+	orientation := getVideoOrientation(p)
+	m := database.MediaMetadata{
+		Width:       &p.Width,
+		Height:      &p.Height,
+		Rotation:    &p.Rotation,
+		Orientation: &orientation,
+	}
+	if p.Duration > 0 {
+		m.DurationSeconds = &p.Duration
+	}
+	if p.VideoCodec != "" {
+		m.VideoCodec = &p.VideoCodec
+	}
+	if p.FrameRate > 0 {
+		m.FrameRate = &p.FrameRate
+	}
+	if p.HasAudio {
+		m.AudioCodec = &p.AudioCodec
+		m.AudioChannels = &p.AudioChannels
+	}
+	if p.Bitrate > 0 {
+		m.Bitrate = &p.Bitrate
+	}
+	if p.Format != "" {
+		m.ContainerFormat = &p.Format
+	}
+	return m
+}
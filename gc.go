@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// Garbage collector modes
+const (
+	GCModeDelete     = "delete"
+	GCModeQuarantine = "quarantine"
+)
+
+// QuarantinePrefix is where quarantined orphans are moved to
+const QuarantinePrefix = "quarantine"
+
+// gcOptions configures a garbage collection run
+type gcOptions struct {
+	GracePeriod time.Duration
+	Mode        string
+	DryRun      bool
+}
+
+// gcOrphan is a stored object that no video references
+type gcOrphan struct {
+	Store        string
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+func newGCOrphan(store string, obj storage.ObjectInfo) gcOrphan {
+	return gcOrphan{
+		Store:        store,
+		Key:          obj.Key,
+		Size:         obj.Size,
+		LastModified: obj.LastModified,
+	}
+}
+
+// gcReport summarises a garbage collection run
+type gcReport struct {
+	Scanned int
+	Orphans []gcOrphan
+	Removed int
+	Errors  []error
+}
+
+func (r gcReport) String() string {
+	var b strings.Builder
+	for _, orphan := range r.Orphans {
+		fmt.Fprintf(&b, "orphan %s %s (%d bytes, modified %s)\n",
+			orphan.Store, orphan.Key, orphan.Size, orphan.LastModified.Format(time.RFC3339))
+	}
+	for _, err := range r.Errors {
+		fmt.Fprintf(&b, "error: %v\n", err)
+	}
+	fmt.Fprintf(&b, "scanned %d objects, %d orphans, %d removed, %d errors", r.Scanned, len(r.Orphans), r.Removed, len(r.Errors))
+	return b.String()
+}
+
+// collectGarbage finds stored objects that no video references and that are
+// older than the grace period, then deletes or quarantines them. The grace
+// period protects uploads whose database update hasn't happened yet.
+func (cfg *apiConfig) collectGarbage(ctx context.Context, opts gcOptions) (gcReport, error) {
+	report := gcReport{}
+
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return report, fmt.Errorf("couldn't get videos: %w", err)
+	}
+
+	referencedVideos := map[string]bool{}
+	referencedThumbnails := map[string]bool{}
+	referencedStreams := map[string]bool{}
+	for _, video := range videos {
+		referencedStreams[streamKeyPrefix(video.ID)] = true
+		if key, ok := cfg.videoKey(video); ok {
+			referencedVideos[key] = true
+		}
+		for _, key := range cfg.thumbnailKeys(video) {
+			referencedThumbnails[key] = true
+		}
+	}
+
+	cutoff := time.Now().Add(-opts.GracePeriod)
+	isOrphan := func(obj storage.ObjectInfo, referenced map[string]bool) bool {
+		if obj.LastModified.After(cutoff) || referenced[obj.Key] {
+			return false
+		}
+		// renditions live under the name of their video file
+		for dir := path.Dir(obj.Key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if referenced[dir+MP4Extension] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, prefix := range []string{LandscapePrefix, PortraitPrefix, SquarePrefix, UltrawidePrefix, OtherPrefix, UploadsPrefix} {
+		objects, err := cfg.videoStorage.List(ctx, prefix+"/")
+		if err != nil {
+			return report, fmt.Errorf("couldn't list %s/: %w", prefix, err)
+		}
+		report.Scanned += len(objects)
+		for _, obj := range objects {
+			if isOrphan(obj, referencedVideos) {
+				report.Orphans = append(report.Orphans, newGCOrphan(AssetStoreVideo, obj))
+			}
+		}
+	}
+
+	// streaming output belongs to the video named by its prefix
+	objects, err := cfg.videoStorage.List(ctx, StreamsPrefix+"/")
+	if err != nil {
+		return report, fmt.Errorf("couldn't list %s/: %w", StreamsPrefix, err)
+	}
+	report.Scanned += len(objects)
+	for _, obj := range objects {
+		if obj.LastModified.After(cutoff) || referencedStreams[streamPrefixOfKey(obj.Key)] {
+			continue
+		}
+		report.Orphans = append(report.Orphans, newGCOrphan(AssetStoreVideo, obj))
+	}
+
+	// thumbnails are stored flat, anything nested belongs to another prefix
+	// when both stores share a backend
+	objects, err = cfg.assetStorage.List(ctx, "")
+	if err != nil {
+		return report, fmt.Errorf("couldn't list thumbnails: %w", err)
+	}
+	for _, obj := range objects {
+		if strings.Contains(obj.Key, "/") {
+			continue
+		}
+		report.Scanned++
+		if isOrphan(obj, referencedThumbnails) {
+			report.Orphans = append(report.Orphans, newGCOrphan(AssetStoreThumbnail, obj))
+		}
+	}
+
+	if opts.DryRun {
+		return report, nil
+	}
+
+	for _, orphan := range report.Orphans {
+		store, _ := cfg.blobStore(orphan.Store)
+		var err error
+		if opts.Mode == GCModeQuarantine {
+			err = quarantineObject(ctx, store, orphan.Key)
+		} else {
+			err = store.Delete(ctx, orphan.Key)
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Errorf("%s %s: %w", orphan.Store, orphan.Key, err))
+			continue
+		}
+		report.Removed++
+	}
+
+	return report, nil
+}
+
+// streamPrefixOfKey returns the per-video stream prefix a key is stored
+// under, e.g. streams/<videoID>/
+func streamPrefixOfKey(key string) string {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 3 {
+		return key
+	}
+	return parts[0] + "/" + parts[1] + "/"
+}
+
+// quarantineObject moves an object under QuarantinePrefix
+func quarantineObject(ctx context.Context, store storage.BlobStore, key string) error {
+	body, info, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	err = store.Put(ctx, path.Join(QuarantinePrefix, key), body, info.ContentType)
+	if err != nil {
+		return err
+	}
+	return store.Delete(ctx, key)
+}
+
+// runGarbageCollector collects garbage every interval until ctx is done
+func (cfg *apiConfig) runGarbageCollector(ctx context.Context, interval time.Duration, opts gcOptions) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := cfg.collectGarbage(ctx, opts)
+			if err != nil {
+				log.Printf("Garbage collection failed: %v", err)
+				continue
+			}
+			log.Printf("Garbage collection: %s", report)
+		}
+	}
+}
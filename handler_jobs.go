@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerJobGet(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithError(w, StatusBadRequest, "Invalid job ID", err)
+		return
+	}
+
+	userID, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, StatusUnauthorized, "Authentication failed", err)
+		return
+	}
+
+	job, err := cfg.db.GetJob(jobID)
+	if errors.Is(err, database.ErrNotFound) {
+		respondWithError(w, StatusNotFound, "Job not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, StatusInternalServerError, "Couldn't get job", err)
+		return
+	}
+	if job.UserID != userID {
+		respondWithError(w, StatusNotFound, "Job not found", nil)
+		return
+	}
+
+	respondWithJSON(w, StatusOK, job)
+}
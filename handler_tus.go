@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tus"
+	"github.com/google/uuid"
+)
+
+// handlerTusOptions advertises the supported tus version and extensions
+func (cfg *apiConfig) handlerTusOptions(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	w.Header().Set("Tus-Version", TusVersion)
+	w.Header().Set("Tus-Extension", TusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(MaxVideoUploadSize))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerTusCreate implements the tus creation extension for a video
+func (cfg *apiConfig) handlerTusCreate(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	videoID, err := cfg.parseAndValidateVideoID(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	userID, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Authentication failed", err)
+		return
+	}
+
+	if _, err := cfg.getAndAuthorizeVideo(videoID, userID); err != nil {
+		respondWithVideoAccessError(w, err)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Length", err)
+		return
+	}
+	if length > MaxVideoUploadSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large", nil)
+		return
+	}
+
+	metadata, err := tus.ParseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Metadata", err)
+		return
+	}
+	if fileType := metadata["filetype"]; fileType != "" {
+		if _, ok := cfg.videoInputFormat(fileType); !ok {
+			respondWithError(w, http.StatusBadRequest, "Unsupported video format", cfg.unsupportedVideoTypeError())
+			return
+		}
+	}
+
+	upload, err := cfg.tusStore.Create(tus.Upload{
+		VideoID:  videoID,
+		UserID:   userID,
+		Length:   length,
+		Metadata: metadata,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+
+	cfg.setVideoStatus(videoID, database.VideoStatusUploading)
+
+	w.Header().Set("Location", fmt.Sprintf("/api/video_upload/%s/tus/%s", videoID, upload.ID))
+	w.Header().Set("Upload-Offset", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handlerTusHead reports the current offset of an upload
+func (cfg *apiConfig) handlerTusHead(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	upload, ok := cfg.getAuthorizedTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlerTusPatch appends a chunk to an upload. Once all bytes are in, the
+// file is queued for the same processing as a regular video upload and the
+// job ID is returned in the Tubely-Job-ID header. If queueing fails the data
+// is kept, so a PATCH at the final offset retries it.
+func (cfg *apiConfig) handlerTusPatch(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	if !checkTusResumable(w, r) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, MaxVideoUploadSize)
+
+	if r.Header.Get("Content-Type") != TusMediaType {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be "+TusMediaType, nil)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Offset", err)
+		return
+	}
+
+	upload, ok := cfg.getAuthorizedTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	newOffset, err := cfg.tusStore.Append(upload.ID, offset, r.Body)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	if err != nil {
+		switch {
+		case errors.Is(err, tus.ErrOffsetMismatch):
+			respondWithError(w, http.StatusConflict, "Upload-Offset does not match", err)
+		case errors.Is(err, tus.ErrLocked):
+			respondWithError(w, http.StatusLocked, "Upload is in use", err)
+		case errors.Is(err, tus.ErrNotFound):
+			respondWithError(w, http.StatusNotFound, "Upload not found", err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, "Couldn't write upload", err)
+		}
+		return
+	}
+
+	if newOffset < upload.Length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	job, err := cfg.finishTusUpload(upload)
+	if err != nil {
+		var validationErr ValidationError
+		switch {
+		case errors.As(err, &validationErr):
+			respondWithError(w, http.StatusBadRequest, "Invalid uploaded file", err)
+		case errors.Is(err, tus.ErrLocked):
+			respondWithError(w, http.StatusLocked, "Upload is in use", err)
+		case errors.Is(err, database.ErrNotFound):
+			respondWithError(w, http.StatusNotFound, "Video not found", err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, "Failed to queue video processing", err)
+		}
+		return
+	}
+	w.Header().Set("Tubely-Job-ID", job.ID.String())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerTusDelete implements the tus termination extension
+func (cfg *apiConfig) handlerTusDelete(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	upload, ok := cfg.getAuthorizedTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.tusStore.Delete(upload.ID)
+	if err != nil {
+		if errors.Is(err, tus.ErrLocked) {
+			respondWithError(w, http.StatusLocked, "Upload is in use", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete upload", err)
+		return
+	}
+	cfg.restoreVideoStatus(upload.VideoID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishTusUpload moves a completed upload to the job spool directory,
+// queues its processing and removes the upload afterwards
+func (cfg *apiConfig) finishTusUpload(upload tus.Upload) (database.Job, error) {
+	if err := cfg.tusStore.Lock(upload.ID); err != nil {
+		return database.Job{}, err
+	}
+	defer cfg.tusStore.Unlock(upload.ID)
+
+	video, err := cfg.getAndAuthorizeVideo(upload.VideoID, upload.UserID)
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	// uploads created without a filetype are assumed to be MP4
+	mediaType := upload.Metadata["filetype"]
+	if mediaType == "" {
+		mediaType = VideoMP4Type
+	}
+
+	head, err := readHead(cfg.tusStore.DataPath(upload.ID))
+	if err != nil {
+		return database.Job{}, err
+	}
+	mediaType, err = cfg.checkSniffedVideoType(mediaType, head)
+	if err != nil {
+		// the upload is complete, resuming it can't change its content
+		if err := cfg.tusStore.Remove(upload.ID); err != nil {
+			log.Printf("Couldn't remove rejected tus upload %s: %v", upload.ID, err)
+		}
+		cfg.restoreVideoStatus(video.ID)
+		return database.Job{}, err
+	}
+
+	spoolPath, err := cfg.spoolVideoFile(video.ID, cfg.tusStore.DataPath(upload.ID), mediaType)
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	job, err := cfg.enqueueVideoProcessing(video.UserID, processVideoPayload{
+		VideoID:    video.ID,
+		SourcePath: spoolPath,
+		MediaType:  mediaType,
+	})
+	if err != nil {
+		os.Remove(spoolPath)
+		return database.Job{}, err
+	}
+	cfg.setVideoStatus(video.ID, database.VideoStatusProcessing)
+
+	if err := cfg.tusStore.Remove(upload.ID); err != nil {
+		log.Printf("Couldn't remove finished tus upload %s: %v", upload.ID, err)
+	}
+	return job, nil
+}
+
+// getAuthorizedTusUpload loads the upload named in the path and checks that
+// it belongs to the authenticated user and the video in the path
+func (cfg *apiConfig) getAuthorizedTusUpload(w http.ResponseWriter, r *http.Request) (tus.Upload, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return tus.Upload{}, false
+	}
+
+	userID, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Authentication failed", err)
+		return tus.Upload{}, false
+	}
+
+	upload, err := cfg.tusStore.Get(r.PathValue("uploadID"))
+	if err != nil {
+		if errors.Is(err, tus.ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "Upload not found", err)
+			return tus.Upload{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return tus.Upload{}, false
+	}
+
+	if upload.VideoID != videoID || upload.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return tus.Upload{}, false
+	}
+	return upload, true
+}
+
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", TusVersion)
+}
+
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") != TusVersion {
+		w.Header().Set("Tus-Version", TusVersion)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported tus version", nil)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// PresignedUploadPart is a presigned URL for one part of a multipart upload
+type PresignedUploadPart struct {
+	PartNumber int32  `json:"part_number"`
+	URL        string `json:"url"`
+}
+
+// PresignedUploadResponse tells the client where to PUT the video. Small
+// files get a single URL, larger ones an upload ID and one URL per part.
+type PresignedUploadResponse struct {
+	Key         string                `json:"key"`
+	ContentType string                `json:"content_type"`
+	URL         string                `json:"url,omitempty"`
+	UploadID    string                `json:"upload_id,omitempty"`
+	PartSize    int64                 `json:"part_size,omitempty"`
+	Parts       []PresignedUploadPart `json:"parts,omitempty"`
+	ExpiresAt   time.Time             `json:"expires_at"`
+	CompleteURL string                `json:"complete_url"`
+}
+
+func (cfg *apiConfig) handlerVideoUploadPresign(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+	}
+
+	videoID, err := cfg.parseAndValidateVideoID(r)
+	if err != nil {
+		respondWithError(w, StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	userID, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, StatusUnauthorized, "Authentication failed", err)
+		return
+	}
+
+	if _, err := cfg.getAndAuthorizeVideo(videoID, userID); err != nil {
+		respondWithVideoAccessError(w, err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	format, ok := cfg.videoInputFormat(params.ContentType)
+	if !ok {
+		respondWithError(w, StatusBadRequest, "Unsupported video format", cfg.unsupportedVideoTypeError())
+		return
+	}
+	if params.Size <= 0 || params.Size > MaxVideoUploadSize {
+		respondWithError(w, StatusBadRequest, "Invalid video size", nil)
+		return
+	}
+
+	key := fmt.Sprintf("%s%s%s", uploadStagingPrefix(videoID), getRandomAssetsName(32), format.Extension)
+	response := PresignedUploadResponse{
+		Key:         key,
+		ContentType: params.ContentType,
+		ExpiresAt:   time.Now().UTC().Add(cfg.presignUploadTTL),
+		CompleteURL: fmt.Sprintf("/api/video_upload/%s/complete", videoID),
+	}
+
+	partSize := cfg.s3Multipart.PartSize
+	presigner, canMultipart := cfg.videoStorage.(storage.MultipartPresigner)
+	if params.Size <= partSize || !canMultipart {
+		url, err := cfg.videoStorage.PresignPut(r.Context(), key, params.ContentType, cfg.presignUploadTTL)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotSupported) {
+				respondWithError(w, http.StatusNotImplemented, "Storage backend doesn't support direct uploads", err)
+				return
+			}
+			respondWithError(w, StatusBadGateway, "Couldn't presign upload", err)
+			return
+		}
+		response.URL = url
+		cfg.setVideoStatus(videoID, database.VideoStatusUploading)
+		respondWithJSON(w, StatusOK, response)
+		return
+	}
+
+	uploadID, err := presigner.CreateMultipartUpload(r.Context(), key, params.ContentType)
+	if err != nil {
+		respondWithError(w, StatusBadGateway, "Couldn't create multipart upload", err)
+		return
+	}
+	response.UploadID = uploadID
+	response.PartSize = partSize
+
+	numParts := int32((params.Size + partSize - 1) / partSize)
+	for partNumber := int32(1); partNumber <= numParts; partNumber++ {
+		url, err := presigner.PresignUploadPart(r.Context(), key, uploadID, partNumber, cfg.presignUploadTTL)
+		if err != nil {
+			presigner.AbortMultipartUpload(context.Background(), key, uploadID)
+			respondWithError(w, StatusBadGateway, "Couldn't presign upload part", err)
+			return
+		}
+		response.Parts = append(response.Parts, PresignedUploadPart{PartNumber: partNumber, URL: url})
+	}
+
+	cfg.setVideoStatus(videoID, database.VideoStatusUploading)
+	respondWithJSON(w, StatusOK, response)
+}
+
+// handlerVideoUploadComplete finishes a direct upload: it completes the
+// multipart upload if there is one, checks the object landed in the bucket
+// and runs it through the usual processing before pointing the video at it.
+// The staging object is only removed on success so the call can be retried.
+func (cfg *apiConfig) handlerVideoUploadComplete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Key      string                  `json:"key"`
+		UploadID string                  `json:"upload_id"`
+		Parts    []storage.CompletedPart `json:"parts"`
+	}
+
+	videoID, err := cfg.parseAndValidateVideoID(r)
+	if err != nil {
+		respondWithError(w, StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	userID, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, StatusUnauthorized, "Authentication failed", err)
+		return
+	}
+
+	video, err := cfg.getAndAuthorizeVideo(videoID, userID)
+	if err != nil {
+		respondWithVideoAccessError(w, err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !strings.HasPrefix(params.Key, uploadStagingPrefix(videoID)) {
+		respondWithError(w, StatusBadRequest, "Key doesn't belong to this video", nil)
+		return
+	}
+
+	if params.UploadID != "" {
+		presigner, ok := cfg.videoStorage.(storage.MultipartPresigner)
+		if !ok {
+			respondWithError(w, http.StatusNotImplemented, "Storage backend doesn't support multipart uploads", nil)
+			return
+		}
+		err := presigner.CompleteMultipartUpload(r.Context(), params.Key, params.UploadID, params.Parts)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			respondWithError(w, StatusBadGateway, "Couldn't complete multipart upload", err)
+			return
+		}
+	}
+
+	info, err := cfg.videoStorage.Head(r.Context(), params.Key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			respondWithError(w, StatusNotFound, "Uploaded video not found", err)
+			return
+		}
+		respondWithError(w, StatusBadGateway, "Couldn't check uploaded video", err)
+		return
+	}
+	if info.Size > MaxVideoUploadSize {
+		cfg.videoStorage.Delete(r.Context(), params.Key)
+		cfg.restoreVideoStatus(videoID)
+		respondWithError(w, StatusBadRequest, "Uploaded video is too large", nil)
+		return
+	}
+
+	// the staging key carries the extension of the format presigned for it
+	mediaType := VideoMP4Type
+	for _, format := range videoInputFormats {
+		if path.Ext(params.Key) == format.Extension {
+			mediaType = format.MediaTypes[0]
+		}
+	}
+
+	job, err := cfg.enqueueVideoProcessing(userID, processVideoPayload{
+		VideoID:   video.ID,
+		SourceKey: params.Key,
+		MediaType: mediaType,
+	})
+	if err != nil {
+		respondWithError(w, StatusInternalServerError, "Failed to queue video processing", err)
+		return
+	}
+	cfg.setVideoStatus(videoID, database.VideoStatusProcessing)
+
+	respondWithJSON(w, StatusAccepted, newVideoJobResponse(job))
+}
+
+// downloadVideoToTemp copies an object from video storage to a temp file
+func (cfg *apiConfig) downloadVideoToTemp(ctx context.Context, key string) (string, error) {
+	body, _, err := cfg.videoStorage.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	tmpFile, err := os.CreateTemp("", "tubely-download-*"+path.Ext(key))
+	if err != nil {
+		return "", NewFileProcessingError("temp_file", "couldn't create temp file")
+	}
+	defer tmpFile.Close()
+
+	if _, err := io.Copy(tmpFile, body); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", NewFileProcessingError("copy", "couldn't copy to temp file")
+	}
+	return tmpFile.Name(), nil
+}
+
+// uploadStagingPrefix is where direct uploads for a video land before they
+// are processed
+func uploadStagingPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("%s/%s/", UploadsPrefix, videoID)
+}
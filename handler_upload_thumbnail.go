@@ -1,12 +1,13 @@
 package main
 
 import (
+	"errors"
 	"io"
 	"mime"
 	"net/http"
-	"os"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
@@ -44,28 +45,30 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
 		return
 	}
-	if mediaType != ImageJPEGType && mediaType != ImagePNGType {
+	if mediaType != ImageJPEGType && mediaType != ImagePNGType && mediaType != ImageWebPType {
 		respondWithError(w, StatusBadRequest, "Invalid file type", nil)
 		return
 	}
 
-	assetPath := getAssetPath(getRandomAssetsName(32), mediaType)
-	assetDiskPath := cfg.getAssetDiskPath(assetPath)
-
-	dst, err := os.Create(assetDiskPath)
+	data, err := io.ReadAll(io.LimitReader(file, MaxThumbnailSize+1))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to create file on server", err)
+		respondWithError(w, http.StatusBadRequest, "Unable to read thumbnail", err)
 		return
 	}
-	defer dst.Close()
-	if _, err = io.Copy(dst, file); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error saving file", err)
+	if len(data) > MaxThumbnailSize {
+		respondWithError(w, http.StatusBadRequest, "Thumbnail is too large", nil)
+		return
+	}
+
+	// check the bytes are what the client claimed before decoding them
+	if _, err := checkSniffedImageType(mediaType, data, ImageJPEGType, ImagePNGType, ImageWebPType); err != nil {
+		respondWithError(w, StatusBadRequest, "Invalid file type", err)
 		return
 	}
 
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
+		respondWithError(w, databaseErrorStatus(err), "Couldn't find video", err)
 		return
 	}
 	if video.UserID != userID {
@@ -73,13 +76,42 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	url := cfg.getAssetURL(assetPath)
-	video.ThumbnailURL = &url
-	err = cfg.db.UpdateVideo(video)
+	url, srcset, keys, err := cfg.storeThumbnail(r.Context(), data)
+	var validationErr ValidationError
+	if errors.As(err, &validationErr) {
+		respondWithError(w, StatusBadRequest, "Invalid thumbnail", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error saving file", err)
+		return
+	}
+
+	// only the thumbnail is written, a processing job may have updated the
+	// rest of the video while the variants were encoded
+	previousURL, previousSrcset, err := cfg.db.SetVideoThumbnail(videoID, url, srcset)
+	if err != nil {
+		cfg.deleteThumbnailKeys(r.Context(), keys)
+		respondWithError(w, databaseErrorStatus(err), "Couldn't update video", err)
+		return
+	}
+	// the replaced variants are no longer referenced
+	cfg.deleteThumbnailKeys(r.Context(), cfg.thumbnailKeys(database.Video{
+		ThumbnailURL:    previousURL,
+		ThumbnailSrcset: previousSrcset,
+	}))
+
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, databaseErrorStatus(err), "Couldn't get video", err)
+		return
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video, cfg.defaultURLSigningOptions())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate video URL", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, video)
+	respondWithJSON(w, http.StatusOK, signedVideo)
 }
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
+	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
 	"github.com/google/uuid"
 )
 
@@ -20,17 +24,10 @@ type VideoUploadRequest struct {
 	VideoID   uuid.UUID
 	UserID    uuid.UUID
 	File      io.ReadCloser
-	Header    *multipart.FileHeader
+	Filename  string
 	MediaType string
 }
 
-// VideoUploadResponse represents the video upload response
-type VideoUploadResponse struct {
-	VideoID  uuid.UUID `json:"video_id"`
-	VideoURL string    `json:"video_url"`
-	Message  string    `json:"message"`
-}
-
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
 	// Step 1: Setup request limits
 	r.Body = http.MaxBytesReader(w, r.Body, MaxVideoUploadSize)
@@ -52,7 +49,7 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	// Step 4: Get and authorize video access
 	video, err := cfg.getAndAuthorizeVideo(videoID, userID)
 	if err != nil {
-		respondWithError(w, StatusUnauthorized, "Not authorized to update this video", err)
+		respondWithVideoAccessError(w, err)
 		return
 	}
 
@@ -63,35 +60,33 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	defer uploadReq.File.Close()
+	uploadReq.VideoID = videoID
+	uploadReq.UserID = userID
+	cfg.setVideoStatus(videoID, database.VideoStatusUploading)
 
-	// Step 6: Process video file
-	processedVideoPath, err := cfg.processVideoFile(uploadReq)
-	if err != nil {
-		respondWithError(w, StatusInternalServerError, "Failed to process video", err)
-		return
-	}
-
-	// Step 7: Upload to S3
-	s3Key, err := cfg.uploadVideoToS3(processedVideoPath, uploadReq.MediaType)
+	// Step 6: Spool the upload for the processing worker
+	spoolPath, err := cfg.spoolVideo(uploadReq)
 	if err != nil {
-		respondWithError(w, StatusBadGateway, "Failed to upload to S3", err)
+		cfg.failVideo(videoID, err)
+		respondWithError(w, StatusInternalServerError, "Failed to save uploaded video", err)
 		return
 	}
+	cfg.setVideoStatus(videoID, database.VideoStatusProcessing)
 
-	// Step 8: Update database
-	err = cfg.updateVideoInDatabase(video, s3Key)
+	// Step 7: Queue processing and return the job
+	job, err := cfg.enqueueVideoProcessing(video.UserID, processVideoPayload{
+		VideoID:    video.ID,
+		SourcePath: spoolPath,
+		MediaType:  uploadReq.MediaType,
+	})
 	if err != nil {
-		respondWithError(w, StatusInternalServerError, "Failed to update database", err)
+		os.Remove(spoolPath)
+		cfg.failVideo(videoID, err)
+		respondWithError(w, StatusInternalServerError, "Failed to queue video processing", err)
 		return
 	}
 
-	// Step 9: Return success response
-	response := VideoUploadResponse{
-		VideoID:  videoID,
-		VideoURL: fmt.Sprintf("https://%s/%s", cfg.s3CfDistribution, s3Key),
-		Message:  "Video uploaded successfully",
-	}
-	respondWithJSON(w, StatusOK, response)
+	respondWithJSON(w, StatusAccepted, newVideoJobResponse(job))
 }
 
 // parseAndValidateVideoID extracts and validates the video ID from the request
@@ -127,6 +122,9 @@ func (cfg *apiConfig) authenticateUser(r *http.Request) (uuid.UUID, error) {
 // getAndAuthorizeVideo retrieves the video and checks user authorization
 func (cfg *apiConfig) getAndAuthorizeVideo(videoID, userID uuid.UUID) (*database.Video, error) {
 	video, err := cfg.db.GetVideo(videoID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
 	if err != nil {
 		return nil, NewFileProcessingError("database", "couldn't find video")
 	}
@@ -138,61 +136,113 @@ func (cfg *apiConfig) getAndAuthorizeVideo(videoID, userID uuid.UUID) (*database
 	return &video, nil
 }
 
-// parseAndValidateUploadedFile parses and validates the uploaded video file
+// respondWithVideoAccessError reports a getAndAuthorizeVideo failure
+func respondWithVideoAccessError(w http.ResponseWriter, err error) {
+	var authErr AuthorizationError
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		respondWithError(w, StatusNotFound, "Video not found", err)
+	case errors.As(err, &authErr):
+		respondWithError(w, StatusUnauthorized, "Not authorized to update this video", err)
+	default:
+		respondWithError(w, StatusInternalServerError, "Couldn't get video", err)
+	}
+}
+
+// parseAndValidateUploadedFile finds the video part of the multipart body
+// and validates it. The part is streamed rather than parsed with FormFile,
+// which would spool the whole upload to a temp file first.
 func (cfg *apiConfig) parseAndValidateUploadedFile(r *http.Request) (*VideoUploadRequest, error) {
-	file, header, err := r.FormFile("video")
+	reader, err := r.MultipartReader()
 	if err != nil {
-		return nil, NewValidationError("video", "unable to parse form file")
+		return nil, NewValidationError("video", "expected a multipart/form-data body")
+	}
+
+	var part *multipart.Part
+	for {
+		part, err = reader.NextPart()
+		if err == io.EOF {
+			return nil, NewValidationError("video", "video file is required")
+		}
+		if err != nil {
+			return nil, NewValidationError("video", "unable to parse form file")
+		}
+		if part.FormName() == "video" {
+			break
+		}
+		part.Close()
 	}
 
-	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
 	if err != nil {
+		part.Close()
 		return nil, NewValidationError("video", "invalid content type")
 	}
 
-	if mediaType != VideoMP4Type {
-		return nil, NewValidationError("video", "only MP4 videos are supported")
+	if _, ok := cfg.videoInputFormat(mediaType); !ok {
+		part.Close()
+		return nil, cfg.unsupportedVideoTypeError()
+	}
+
+	// the claimed type is only a hint, check it against the file's content
+	buffered := bufio.NewReaderSize(part, sniffLen)
+	head, err := buffered.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		part.Close()
+		return nil, NewValidationError("video", "unable to read video file")
+	}
+	mediaType, err = cfg.checkSniffedVideoType(mediaType, head)
+	if err != nil {
+		part.Close()
+		return nil, err
 	}
 
 	return &VideoUploadRequest{
-		File:      file,
-		Header:    header,
+		File: struct {
+			io.Reader
+			io.Closer
+		}{buffered, part},
+		Filename:  part.FileName(),
 		MediaType: mediaType,
 	}, nil
 }
 
-// processVideoFile processes the uploaded video file
-func (cfg *apiConfig) processVideoFile(req *VideoUploadRequest) (string, error) {
-	// Create temporary file
-	tmpFile, err := os.CreateTemp("", "tubely-upload.mp4")
-	if err != nil {
-		return "", NewFileProcessingError("temp_file", "couldn't create temp file")
+// spoolVideo copies the uploaded file to the job spool directory, where it
+// stays until the processing job is done with it
+func (cfg *apiConfig) spoolVideo(req *VideoUploadRequest) (string, error) {
+	ext := MP4Extension
+	if format, ok := cfg.videoInputFormat(req.MediaType); ok {
+		ext = format.Extension
 	}
-	defer os.Remove(tmpFile.Name())
-	defer tmpFile.Close()
 
-	// Copy uploaded file to temp file
-	if _, err := io.Copy(tmpFile, req.File); err != nil {
-		return "", NewFileProcessingError("copy", "couldn't copy to temp file")
+	spoolFile, err := os.CreateTemp(cfg.jobSpoolDir, req.VideoID.String()+"-*"+ext)
+	if err != nil {
+		return "", NewFileProcessingError("spool_file", "couldn't create spool file")
 	}
+	defer spoolFile.Close()
 
-	// Reset file pointer to beginning
-	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
-		return "", NewFileProcessingError("seek", "couldn't seek temp file")
+	if _, err := io.Copy(spoolFile, req.File); err != nil {
+		os.Remove(spoolFile.Name())
+		return "", NewFileProcessingError("copy", "couldn't copy to spool file")
 	}
+	return spoolFile.Name(), nil
+}
 
-	// Process video for fast start streaming
-	fastStartFilePath, err := processVideoForFastStart(tmpFile.Name())
+// spoolVideoFile copies a file that is already on disk to the job spool
+// directory, srcPath itself is left in place
+func (cfg *apiConfig) spoolVideoFile(videoID uuid.UUID, srcPath, mediaType string) (string, error) {
+	src, err := os.Open(srcPath)
 	if err != nil {
-		return "", NewFileProcessingError("fast_start", "couldn't create fast start file")
+		return "", NewFileProcessingError("open", "couldn't open uploaded file")
 	}
+	defer src.Close()
 
-	return fastStartFilePath, nil
+	return cfg.spoolVideo(&VideoUploadRequest{VideoID: videoID, File: src, MediaType: mediaType})
 }
 
-// uploadVideoToS3 uploads the processed video to S3
-func (cfg *apiConfig) uploadVideoToS3(processedVideoPath, mediaType string) (string, error) {
-	ctx := context.Background()
+// uploadVideoToS3 uploads the processed video to the video storage backend,
+// keyed under the prefix for its orientation
+func (cfg *apiConfig) uploadVideoToS3(ctx context.Context, processedVideoPath, mediaType string, probe videoProbe) (string, error) {
 	// Open processed video file
 	fastStartFile, err := os.Open(processedVideoPath)
 	if err != nil {
@@ -202,31 +252,140 @@ func (cfg *apiConfig) uploadVideoToS3(processedVideoPath, mediaType string) (str
 	defer fastStartFile.Close()
 
 	// Generate S3 key
-	prefix, err := getVideoAspectRatio(processedVideoPath)
+	prefix := getVideoOrientation(probe)
+	s3Key := fmt.Sprintf("%s/%s%s", prefix, getRandomAssetsName(32), MP4Extension)
+
+	// Upload to storage
+	err = cfg.videoStorage.Put(ctx, s3Key, fastStartFile, mediaType)
 	if err != nil {
-		return "", NewFileProcessingError("aspect_ratio", "couldn't determine video aspect ratio")
+		return "", NewStorageError("upload", fmt.Sprintf("failed to upload video: %v", err))
 	}
 
-	s3Key := fmt.Sprintf("%s/%s%s", prefix, getRandomAssetsName(32), MP4Extension)
+	return s3Key, nil
+}
 
-	// Upload to S3
-	_, err = cfg.s3Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:      &cfg.s3Bucket,
-		Key:         &s3Key,
-		Body:        fastStartFile,
-		ContentType: &mediaType,
-	})
+// storeVideoFromPath runs the fast start or conversion, upload and database
+// steps for a video that is already on disk, srcPath itself is left in place
+func (cfg *apiConfig) storeVideoFromPath(ctx context.Context, video *database.Video, srcPath, mediaType string) (string, error) {
+	// presigned uploads reach here without having been sniffed
+	head, err := readHead(srcPath)
+	if err != nil {
+		return "", NewFileProcessingError("open", "couldn't read uploaded file")
+	}
+	mediaType, err = cfg.checkSniffedVideoType(mediaType, head)
 	if err != nil {
-		return "", NewS3Error("upload", "failed to upload video to S3")
+		return "", jobs.Permanent(err)
 	}
 
+	probe, err := probeVideo(srcPath)
+	if errors.Is(err, errNoVideoStream) {
+		// retrying won't add a video stream to the file
+		return "", jobs.Permanent(NewValidationError("video", "file has no video stream"))
+	}
+	if err != nil {
+		return "", NewFileProcessingError("probe", fmt.Sprintf("couldn't read video metadata: %v", err))
+	}
+	if err := confirmProbedFormat(mediaType, probe); err != nil {
+		return "", jobs.Permanent(err)
+	}
+	video.Metadata = probe.metadata()
+	video.Metadata.SourceMediaType = &mediaType
+
+	var processedVideoPath string
+	if mediaType == VideoMP4Type {
+		processedVideoPath, err = processVideoForFastStart(srcPath)
+		if err != nil {
+			return "", NewFileProcessingError("fast_start", "couldn't create fast start file")
+		}
+	} else {
+		processedVideoPath, err = convertVideoToMP4(srcPath, probe)
+		if err != nil {
+			return "", NewFileProcessingError("transcode", fmt.Sprintf("couldn't convert video to MP4: %v", err))
+		}
+	}
+
+	s3Key, err := cfg.uploadVideoToS3(ctx, processedVideoPath, VideoMP4Type, probe)
+	if err != nil {
+		return "", err
+	}
+
+	originalKey := ""
+	if mediaType != VideoMP4Type && cfg.archiveOriginals {
+		originalKey, err = cfg.archiveOriginal(ctx, srcPath, s3Key, mediaType)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	streams, err := cfg.storeStreams(ctx, video.ID, srcPath, probe)
+	if err != nil {
+		return "", err
+	}
+	previews := cfg.storePreviews(ctx, srcPath, probe, s3Key)
+
+	if err := cfg.updateVideoInDatabase(ctx, video, s3Key, originalKey, streams, previews); err != nil {
+		return "", err
+	}
+
+	cfg.ensureVideoThumbnail(ctx, video, srcPath)
 	return s3Key, nil
 }
 
-// updateVideoInDatabase updates the video record in the database
-func (cfg *apiConfig) updateVideoInDatabase(video *database.Video, s3Key string) error {
-	videoURL := fmt.Sprintf("https://%s/%s", cfg.s3CfDistribution, s3Key)
+// archiveOriginal stores the uploaded file next to the MP4 converted from it
+func (cfg *apiConfig) archiveOriginal(ctx context.Context, srcPath, videoKey, mediaType string) (string, error) {
+	original, err := os.Open(srcPath)
+	if err != nil {
+		return "", NewFileProcessingError("open", "couldn't open original file")
+	}
+	defer original.Close()
+
+	ext := path.Ext(srcPath)
+	if format, ok := cfg.videoInputFormat(mediaType); ok {
+		ext = format.Extension
+	}
+	key := strings.TrimSuffix(videoKey, path.Ext(videoKey)) + "/" + OriginalName + ext
+
+	if err := cfg.videoStorage.Put(ctx, key, original, mediaType); err != nil {
+		return "", NewStorageError("upload", fmt.Sprintf("failed to archive original: %v", err))
+	}
+	return key, nil
+}
+
+// updateVideoInDatabase points the video at the stored file, archived
+// original, streaming manifests and previews, if any, and marks it ready.
+// When the video was deleted during processing, before or while the row is
+// updated, the stored files are removed and a permanent error wrapping
+// database.ErrNotFound is returned.
+func (cfg *apiConfig) updateVideoInDatabase(ctx context.Context, video *database.Video, s3Key, originalKey string, streams streamOutputs, previews previewOutputs) error {
+	err := cfg.setVideoOutputs(video, s3Key, originalKey, streams, previews)
+	if errors.Is(err, database.ErrNotFound) {
+		// the original, previews and streams are all found from the video
+		// key and ID, the thumbnail went with the deleted row
+		videoURL := cfg.videoStorageRef(s3Key)
+		cfg.deleteVideoAssets(ctx, database.Video{ID: video.ID, VideoURL: &videoURL})
+		return jobs.Permanent(err)
+	}
+	return err
+}
+
+func (cfg *apiConfig) setVideoOutputs(video *database.Video, s3Key, originalKey string, streams streamOutputs, previews previewOutputs) error {
+	// processing can take a while, keep a thumbnail uploaded in the meantime
+	current, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		return err
+	}
+	video.ThumbnailURL = current.ThumbnailURL
+	video.ThumbnailSrcset = current.ThumbnailSrcset
+
+	videoURL := cfg.videoStorageRef(s3Key)
 	video.VideoURL = &videoURL
+	video.OriginalURL = cfg.optionalVideoStorageRef(originalKey)
+	video.HLSURL = cfg.optionalVideoStorageRef(streams.HLSKey)
+	video.DASHURL = cfg.optionalVideoStorageRef(streams.DASHKey)
+	video.PreviewSpriteURL = cfg.optionalVideoStorageRef(previews.SpriteKey)
+	video.PreviewVTTURL = cfg.optionalVideoStorageRef(previews.VTTKey)
+	video.Status = database.VideoStatusReady
+	video.FailureReason = nil
 
 	return cfg.db.UpdateVideo(*video)
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/google/uuid"
+)
+
+// handlerVideoCookies issues CloudFront signed cookies for players that
+// fetch many files (HLS and DASH manifests and segments) and can't carry a
+// signature on each URL. ?asset=streams covers the video's streaming output
+// and ?asset=video every object stored under the video file's name, the
+// default is streams when the video has any. Only the owner of the video
+// can get cookies for it.
+func (cfg *apiConfig) handlerVideoCookies(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Resource  string            `json:"resource"`
+		ExpiresAt time.Time         `json:"expires_at"`
+		Cookies   map[string]string `json:"cookies"`
+	}
+
+	if cfg.cfSigner == nil {
+		respondWithError(w, http.StatusNotImplemented, "Signed cookies are not configured", nil)
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	userID, err := cfg.authenticateUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Authentication failed", err)
+		return
+	}
+
+	signingOpts, err := cfg.parseURLSigningOptions(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid URL signing options", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, databaseErrorStatus(err), "Couldn't get video", err)
+		return
+	}
+	// other users' videos are reported as missing rather than forbidden
+	if video.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has not been uploaded", nil)
+		return
+	}
+	_, key, ok := parseVideoStorageRef(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Video is not stored in the bucket", nil)
+		return
+	}
+
+	asset := r.URL.Query().Get("asset")
+	if asset == "" {
+		asset = "video"
+		if video.HLSURL != nil || video.DASHURL != nil {
+			asset = "streams"
+		}
+	}
+
+	var resource string
+	switch asset {
+	case "streams":
+		if video.HLSURL == nil && video.DASHURL == nil {
+			respondWithError(w, http.StatusNotFound, "Video has no streaming output", nil)
+			return
+		}
+		resource = cfg.videoStorage.URL(streamKeyPrefix(video.ID)) + "*"
+	case "video":
+		resource = cfg.videoPrefixResource(key)
+	default:
+		respondWithError(w, http.StatusBadRequest, "asset must be streams or video", nil)
+		return
+	}
+	expiresAt := time.Now().Add(signingOpts.TTL).UTC()
+	cookies, err := cfg.cfSigner.SignCookies(resource, cdn.Restrictions{
+		Expires:  expiresAt,
+		SourceIP: signingOpts.SourceIP,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign cookies", err)
+		return
+	}
+
+	values := map[string]string{}
+	for _, cookie := range cookies {
+		http.SetCookie(w, cookie)
+		values[cookie.Name] = cookie.Value
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Resource:  resource,
+		ExpiresAt: expiresAt,
+		Cookies:   values,
+	})
+}
@@ -1,8 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
@@ -64,7 +72,7 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, databaseErrorStatus(err), "Couldn't get video", err)
 		return
 	}
 	if video.UserID != userID {
@@ -74,10 +82,13 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 
 	err = cfg.db.DeleteVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+		// a concurrent delete removes the assets itself
+		respondWithError(w, databaseErrorStatus(err), "Couldn't delete video", err)
 		return
 	}
 
+	cfg.deleteVideoAssets(context.WithoutCancel(r.Context()), video)
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -89,13 +100,38 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	signingOpts, err := cfg.parseURLSigningOptions(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid URL signing options", err)
+		return
+	}
+
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, databaseErrorStatus(err), "Couldn't get video", err)
 		return
 	}
+	// anyone can read a video with the default restrictions, choosing
+	// them is reserved for its owner
+	if signingOptionsRequested(r) {
+		userID, err := cfg.authenticateUser(r)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "URL signing options require authentication", err)
+			return
+		}
+		if video.UserID != userID {
+			respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+			return
+		}
+	}
 
-	respondWithJSON(w, http.StatusOK, video)
+	signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video, signingOpts)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate video URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, signedVideo)
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
@@ -110,11 +146,170 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	signingOpts, err := cfg.parseURLSigningOptions(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid URL signing options", err)
+		return
+	}
+
+	query := r.URL.Query()
+	params := database.GetVideosParams{UserID: userID}
+
+	params.Status = database.VideoStatus(query.Get("status"))
+	if params.Status != "" && !params.Status.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Invalid status filter", nil)
+		return
+	}
+
+	params.Orientation = query.Get("orientation")
+	if params.Orientation != "" && !slices.Contains(videoOrientations, params.Orientation) {
+		respondWithError(w, http.StatusBadRequest, "Invalid orientation filter", nil)
+		return
+	}
+
+	params.MinDuration, err = parseDurationFilter(query.Get("min_duration"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid min_duration filter", err)
+		return
+	}
+	params.MaxDuration, err = parseDurationFilter(query.Get("max_duration"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid max_duration filter", err)
+		return
+	}
+
+	params.CreatedAfter, err = parseTimeFilter(query.Get("created_after"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid created_after filter", err)
+		return
+	}
+	params.CreatedBefore, err = parseTimeFilter(query.Get("created_before"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid created_before filter", err)
+		return
+	}
+
+	params.Sort = database.VideoSort(query.Get("sort"))
+	if params.Sort == "" {
+		params.Sort = database.VideoSortCreated
+	}
+	if !params.Sort.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Invalid sort", nil)
+		return
+	}
+	switch query.Get("order") {
+	case "":
+		// titles read alphabetically, everything else newest or longest first
+		params.Descending = params.Sort != database.VideoSortTitle
+	case "asc":
+		params.Descending = false
+	case "desc":
+		params.Descending = true
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid order", nil)
+		return
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := database.ParseVideoCursor(cursor)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.After = &after
+	}
+
+	// paging is opt in so clients that predate it still get every video,
+	// a cursor always pages because it came from a paged response
+	pageSize := 0
+	if query.Has("limit") || params.After != nil {
+		pageSize, err = parsePageSize(query.Get("limit"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit", err)
+			return
+		}
+		// one more than the page tells whether there is a next page
+		params.Limit = pageSize + 1
+	}
+
+	videos, err := cfg.db.GetVideos(params)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Cursor doesn't match the sort order", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
+	if pageSize > 0 && len(videos) > pageSize {
+		videos = videos[:pageSize]
+		next := database.NewVideoCursor(videos[pageSize-1], params.Sort, params.Descending)
+		w.Header().Set("Link", nextPageLink(r.URL, next))
+	}
+
+	for i, video := range videos {
+		videos[i], err = cfg.dbVideoToSignedVideo(r.Context(), video, signingOpts)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate video URL", err)
+			return
+		}
+	}
 
 	respondWithJSON(w, http.StatusOK, videos)
 }
+
+// parseTimeFilter accepts an RFC 3339 time or a date, which stands for the
+// start of that day in UTC. An empty value means no filter.
+func parseTimeFilter(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, NewValidationError("time", "must be an RFC 3339 time or a date such as 2024-01-31")
+	}
+	return t, nil
+}
+
+// parsePageSize returns the number of videos per page, DefaultVideoPageSize
+// when value is empty
+func parsePageSize(value string) (int, error) {
+	if value == "" {
+		return DefaultVideoPageSize, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > MaxVideoPageSize {
+		return 0, NewValidationError("limit", fmt.Sprintf("must be between 1 and %d", MaxVideoPageSize))
+	}
+	return n, nil
+}
+
+// nextPageLink is a Link header value pointing at the page after cursor,
+// with the same filters and sort as the current request
+func nextPageLink(current *url.URL, cursor database.VideoCursor) string {
+	query := current.Query()
+	query.Set("cursor", cursor.Encode())
+	next := url.URL{Path: current.Path, RawQuery: query.Encode()}
+	return fmt.Sprintf(`<%s>; rel="next"`, next.String())
+}
+
+// parseDurationFilter accepts a Go duration such as "10m" or a number of
+// seconds, an empty value means no filter
+func parseDurationFilter(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	// a number of seconds past what a Duration holds falls through to the
+	// validation error
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 && seconds*float64(time.Second) < math.MaxInt64 {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, NewValidationError("duration", "must be a duration such as 10m or a number of seconds")
+	}
+	return d, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+func newTestConfig() *apiConfig {
+	return &apiConfig{
+		db:              database.NewMemoryStore(),
+		jwtSecret:       "secret",
+		storageBackend:  StorageBackendMemory,
+		videoStorage:    storage.NewMemoryStore("http://localhost/videos/"),
+		assetStorage:    storage.NewMemoryStore("http://localhost/assets/"),
+		presignGetTTL:   time.Hour,
+		signedURLMaxTTL: 24 * time.Hour,
+	}
+}
+
+func TestHandlerVideosRetrievePages(t *testing.T) {
+	cfg := newTestConfig()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: "a@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	other, err := cfg.db.CreateUser(database.CreateUserParams{Email: "b@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	for _, title := range []string{"a", "b", "c"} {
+		if _, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: title, UserID: user.ID}); err != nil {
+			t.Fatalf("CreateVideo: %v", err)
+		}
+	}
+	if _, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "other", UserID: other.ID}); err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+
+	get := func(target string, authorized bool) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if authorized {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		cfg.handlerVideosRetrieve(w, r)
+		return w
+	}
+	titles := func(w *httptest.ResponseRecorder) []string {
+		t.Helper()
+		var videos []database.Video
+		if err := json.Unmarshal(w.Body.Bytes(), &videos); err != nil {
+			t.Fatalf("decode %s: %v", w.Body, err)
+		}
+		titles := []string{}
+		for _, video := range videos {
+			titles = append(titles, video.Title)
+		}
+		return titles
+	}
+
+	if w := get("/api/videos", false); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a JWT got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := get("/api/videos?sort=size", true); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown sort got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// without a limit every video is returned on one page
+	w := get("/api/videos?sort=title", true)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	if got := strings.Join(titles(w), ","); got != "a,b,c" {
+		t.Errorf("got %s, want a,b,c", got)
+	}
+	if link := w.Header().Get("Link"); link != "" {
+		t.Errorf("unpaged listing has Link %q", link)
+	}
+
+	w = get("/api/videos?sort=title&limit=2", true)
+	if got := strings.Join(titles(w), ","); got != "a,b" {
+		t.Errorf("first page is %s, want a,b", got)
+	}
+	link := w.Header().Get("Link")
+	next, ok := strings.CutPrefix(link, "<")
+	next, _, found := strings.Cut(next, `>; rel="next"`)
+	if !ok || !found {
+		t.Fatalf("first page Link = %q, want a next link", link)
+	}
+
+	w = get(next, true)
+	if got := strings.Join(titles(w), ","); got != "c" {
+		t.Errorf("second page is %s, want c", got)
+	}
+	if link := w.Header().Get("Link"); link != "" {
+		t.Errorf("last page has Link %q", link)
+	}
+}
+
+func TestParseDurationFilter(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"90", 90 * time.Second, false},
+		{"1.5", 1500 * time.Millisecond, false},
+		{"10m", 10 * time.Minute, false},
+		{"9223372036", 9223372036 * time.Second, false},
+		{"9223372037", 0, true},
+		{"1e300", 0, true},
+		{"Inf", 0, true},
+		{"NaN", 0, true},
+		{"-5", 0, true},
+		{"-1m", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDurationFilter(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDurationFilter(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseDurationFilter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
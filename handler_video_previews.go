@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// handlerVideoPreviewTrack serves the WebVTT scrubbing track of a video with
+// each cue pointing at a signed URL of the sprite sheet. The stored track
+// names the sheet relative to itself, which players would resolve against
+// the track URL and fetch without a signature. Track elements can't send a
+// JWT, so the sheet is signed with the default restrictions like an
+// unauthenticated GET /api/videos/{videoID}.
+func (cfg *apiConfig) handlerVideoPreviewTrack(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, databaseErrorStatus(err), "Couldn't get video", err)
+		return
+	}
+	if video.PreviewVTTURL == nil || video.PreviewSpriteURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no previews", nil)
+		return
+	}
+	_, key, ok := parseVideoStorageRef(*video.PreviewVTTURL)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Previews are not stored in the bucket", nil)
+		return
+	}
+
+	spriteURL, err := cfg.signVideoRef(r.Context(), video.PreviewSpriteURL, cfg.defaultURLSigningOptions())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate sprite URL", err)
+		return
+	}
+	if spriteURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no previews", nil)
+		return
+	}
+
+	body, _, err := cfg.videoStorage.Get(r.Context(), key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read preview track", err)
+		return
+	}
+	defer body.Close()
+	track, err := io.ReadAll(body)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read preview track", err)
+		return
+	}
+
+	// the signature expires, so the rewritten track must not be cached
+	// past it
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, rewritePreviewCues(string(track), PreviewSpriteName, *spriteURL))
+}
+
+// rewritePreviewCues replaces the sprite name at the start of each cue
+// payload with spriteURL, keeping the #xywh fragment
+func rewritePreviewCues(track, spriteName, spriteURL string) string {
+	lines := strings.Split(track, "\n")
+	for i, line := range lines {
+		if fragment, ok := strings.CutPrefix(line, spriteName+"#"); ok {
+			lines[i] = spriteURL + "#" + fragment
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// packageHLS splits encoded renditions into MPEG-TS segments and writes a
+// variant playlist per rendition plus the master playlist to outDir. It
+// returns the path of the master playlist relative to outDir.
+func packageHLS(renditions []encodedRendition, outDir string) (string, error) {
+	args := []string{}
+	for _, r := range renditions {
+		args = append(args, "-i", r.Path)
+	}
+
+	streamMap := []string{}
+	for i, r := range renditions {
+		args = append(args, "-map", fmt.Sprintf("%d:v:0", i))
+		stream := fmt.Sprintf("v:%d", i)
+		if r.HasAudio {
+			args = append(args, "-map", fmt.Sprintf("%d:a:0", i))
+			stream += fmt.Sprintf(",a:%d", i)
+		}
+		streamMap = append(streamMap, stream+",name:"+r.Name)
+	}
+
+	args = append(args,
+		"-c", "copy",
+		"-f", "hls",
+		"-hls_time", fmt.Sprint(StreamSegmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(outDir, "%v", "segment_%04d.ts"),
+		"-master_pl_name", HLSMasterPlaylist,
+		"-var_stream_map", strings.Join(streamMap, " "),
+		filepath.Join(outDir, "%v", "index.m3u8"),
+	)
+
+	if err := runFFmpeg(args...); err != nil {
+		return "", err
+	}
+	return HLSMasterPlaylist, nil
+}
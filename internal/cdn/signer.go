@@ -0,0 +1,83 @@
+package cdn
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/cloudfront/sign"
+)
+
+// Restrictions limit where and until when a signed URL or cookie is valid
+type Restrictions struct {
+	Expires time.Time
+	// SourceIP is an optional CIDR the request must come from
+	SourceIP string
+	// Resource is an optional URL pattern, possibly with "*" wildcards, that
+	// the signature covers instead of the exact URL being signed
+	Resource string
+}
+
+// needsCustomPolicy reports whether the restrictions go beyond what a
+// canned policy (exact URL plus expiry) can express
+func (r Restrictions) needsCustomPolicy() bool {
+	return r.SourceIP != "" || r.Resource != ""
+}
+
+func (r Restrictions) policy(resource string) *sign.Policy {
+	if r.Resource != "" {
+		resource = r.Resource
+	}
+	condition := sign.Condition{
+		DateLessThan: sign.NewAWSEpochTime(r.Expires),
+	}
+	if r.SourceIP != "" {
+		condition.IPAddress = &sign.IPAddress{SourceIP: r.SourceIP}
+	}
+	return &sign.Policy{
+		Statements: []sign.Statement{{Resource: resource, Condition: condition}},
+	}
+}
+
+// Signer creates CloudFront signed URLs and signed cookies with a trusted
+// key group key pair
+type Signer struct {
+	urls    *sign.URLSigner
+	cookies *sign.CookieSigner
+}
+
+func NewSigner(keyPairID, privateKeyPath, cookieDomain string) (*Signer, error) {
+	if keyPairID == "" {
+		return nil, errors.New("cdn: key pair ID is required")
+	}
+	privKey, err := sign.LoadPEMPrivKeyFile(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{
+		urls: sign.NewURLSigner(keyPairID, privKey),
+		cookies: sign.NewCookieSigner(keyPairID, privKey, func(o *sign.CookieOptions) {
+			o.Domain = cookieDomain
+			o.Path = "/"
+			o.Secure = true
+		}),
+	}, nil
+}
+
+// SignURL signs rawURL with a canned policy when only an expiry is given and
+// with a custom policy when IP or resource restrictions are set
+func (s *Signer) SignURL(rawURL string, r Restrictions) (string, error) {
+	if !r.needsCustomPolicy() {
+		return s.urls.Sign(rawURL, r.Expires)
+	}
+	return s.urls.SignWithPolicy(rawURL, r.policy(rawURL))
+}
+
+// SignCookies returns the CloudFront-Policy, CloudFront-Signature and
+// CloudFront-Key-Pair-Id cookies granting access to resource, which usually
+// ends in a wildcard so that a playlist and all its segments are covered
+func (s *Signer) SignCookies(resource string, r Restrictions) ([]*http.Cookie, error) {
+	return s.cookies.SignWithPolicy(r.policy(resource), func(o *sign.CookieOptions) {
+		o.Expires = r.Expires
+	})
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AssetDeletion is a stored object that couldn't be deleted and is waiting
+// to be retried
+type AssetDeletion struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	CreateAssetDeletionParams
+}
+
+type CreateAssetDeletionParams struct {
+	Store string `json:"store"`
+	Key   string `json:"key"`
+	// Prefix marks Key as a prefix, every object under it is deleted
+	Prefix bool `json:"prefix"`
+}
+
+func (c Client) CreateAssetDeletion(params CreateAssetDeletionParams, cause error) error {
+	query := `
+	INSERT INTO asset_deletions (
+		id,
+		created_at,
+		updated_at,
+		store,
+		key,
+		prefix,
+		attempts,
+		last_error
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 1, ?)
+	`
+	_, err := c.exec(query, uuid.New(), params.Store, params.Key, params.Prefix, errorString(cause))
+	return err
+}
+
+// GetPendingAssetDeletions returns the oldest deletions that have been
+// attempted fewer than maxAttempts times
+func (c Client) GetPendingAssetDeletions(maxAttempts, limit int) ([]AssetDeletion, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		store,
+		key,
+		prefix,
+		attempts,
+		last_error
+	FROM asset_deletions
+	WHERE attempts < ?
+	ORDER BY updated_at ASC
+	LIMIT ?
+	`
+
+	rows, err := c.query(query, maxAttempts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := []AssetDeletion{}
+	for rows.Next() {
+		var deletion AssetDeletion
+		if err := rows.Scan(
+			&deletion.ID,
+			&deletion.CreatedAt,
+			&deletion.UpdatedAt,
+			&deletion.Store,
+			&deletion.Key,
+			&deletion.Prefix,
+			&deletion.Attempts,
+			&deletion.LastError,
+		); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, deletion)
+	}
+
+	return deletions, rows.Err()
+}
+
+func (c Client) RecordAssetDeletionFailure(id uuid.UUID, cause error) error {
+	query := `
+	UPDATE asset_deletions
+	SET
+		attempts = attempts + 1,
+		last_error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, errorString(cause), id)
+	return err
+}
+
+func (c Client) DeleteAssetDeletion(id uuid.UUID) error {
+	query := `
+	DELETE FROM asset_deletions
+	WHERE id = ?
+	`
+	_, err := c.exec(query, id)
+	return err
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,328 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// testPostgresURLEnv names a Postgres database the tests may use. Each test
+// runs in a schema of its own that is dropped afterwards.
+const testPostgresURLEnv = "TEST_POSTGRES_URL"
+
+// testClients opens a migrated SQLite database and, when
+// TEST_POSTGRES_URL is set, a migrated Postgres schema
+func testClients(t *testing.T) map[string]Client {
+	t.Helper()
+
+	clients := map[string]Client{dialectSQLite: newSQLiteTestClient(t)}
+	if url := os.Getenv(testPostgresURLEnv); url != "" {
+		clients[dialectPostgres] = newPostgresTestClient(t, url)
+	}
+	return clients
+}
+
+func newSQLiteTestClient(t *testing.T) Client {
+	t.Helper()
+
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"), true)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { c.db.Close() })
+	return c
+}
+
+func newPostgresTestClient(t *testing.T, url string) Client {
+	t.Helper()
+
+	admin, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatalf("open %s: %v", testPostgresURLEnv, err)
+	}
+	schema := "tubely_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatalf("create schema: %v", err)
+	}
+
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	c, err := NewClient(url+sep+"search_path="+schema, true)
+	t.Cleanup(func() {
+		if err == nil {
+			c.db.Close()
+		}
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+		admin.Close()
+	})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c
+}
+
+func createTestUser(t *testing.T, s Store, email string) *User {
+	t.Helper()
+
+	user, err := s.CreateUser(CreateUserParams{Email: email, Password: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+func createTestVideo(t *testing.T, s Store, userID uuid.UUID, title string) Video {
+	t.Helper()
+
+	video, err := s.CreateVideo(CreateVideoParams{Title: title, Description: "d", UserID: userID})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	return video
+}
+
+func TestClientUUIDColumns(t *testing.T) {
+	for name, c := range testClients(t) {
+		t.Run(name, func(t *testing.T) {
+			user := createTestUser(t, c, "a@example.com")
+			video := createTestVideo(t, c, user.ID, "first")
+
+			got, err := c.GetVideo(video.ID)
+			if err != nil {
+				t.Fatalf("GetVideo: %v", err)
+			}
+			if got.ID != video.ID || got.UserID != user.ID {
+				t.Errorf("GetVideo = %s of %s, want %s of %s", got.ID, got.UserID, video.ID, user.ID)
+			}
+
+			videos, err := c.GetVideos(GetVideosParams{UserID: user.ID})
+			if err != nil {
+				t.Fatalf("GetVideos: %v", err)
+			}
+			if len(videos) != 1 || videos[0].ID != video.ID {
+				t.Errorf("GetVideos = %v, want only %s", videos, video.ID)
+			}
+
+			_, err = c.CreateRefreshToken(CreateRefreshTokenParams{
+				Token:     "token",
+				UserID:    user.ID,
+				ExpiresAt: time.Now().Add(time.Hour),
+			})
+			if err != nil {
+				t.Fatalf("CreateRefreshToken: %v", err)
+			}
+			owner, err := c.GetUserByRefreshToken("token")
+			if err != nil {
+				t.Fatalf("GetUserByRefreshToken: %v", err)
+			}
+			if owner.ID != user.ID {
+				t.Errorf("GetUserByRefreshToken = %s, want %s", owner.ID, user.ID)
+			}
+
+			if _, err := c.GetVideo(uuid.New()); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetVideo of unknown ID = %v, want ErrNotFound", err)
+			}
+			if _, err := c.CreateUser(CreateUserParams{Email: "a@example.com", Password: "hash"}); !errors.Is(err, ErrConflict) {
+				t.Errorf("CreateUser with taken email = %v, want ErrConflict", err)
+			}
+		})
+	}
+}
+
+// The bounds go through dialect.timestamp, SQLite compares them with the
+// stored CURRENT_TIMESTAMP text so a bound in another zone has to be
+// converted first
+func TestClientCreatedAtFilters(t *testing.T) {
+	zone := time.FixedZone("UTC-5", -5*60*60)
+	for name, c := range testClients(t) {
+		t.Run(name, func(t *testing.T) {
+			user := createTestUser(t, c, "a@example.com")
+			video := createTestVideo(t, c, user.ID, "first")
+			created := video.CreatedAt.In(zone)
+
+			tests := []struct {
+				name   string
+				params GetVideosParams
+				want   int
+			}{
+				{"after inclusive", GetVideosParams{CreatedAfter: created}, 1},
+				{"after later", GetVideosParams{CreatedAfter: created.Add(time.Second)}, 0},
+				{"before exclusive", GetVideosParams{CreatedBefore: created}, 0},
+				{"before later", GetVideosParams{CreatedBefore: created.Add(time.Second)}, 1},
+				{"day", GetVideosParams{CreatedAfter: created.Add(-12 * time.Hour), CreatedBefore: created.Add(12 * time.Hour)}, 1},
+			}
+			for _, tt := range tests {
+				tt.params.UserID = user.ID
+				videos, err := c.GetVideos(tt.params)
+				if err != nil {
+					t.Fatalf("%s: GetVideos: %v", tt.name, err)
+				}
+				if len(videos) != tt.want {
+					t.Errorf("%s: got %d videos, want %d", tt.name, len(videos), tt.want)
+				}
+			}
+		})
+	}
+}
+
+// Videos created in the same second tie on created_at, the cursor value
+// has to compare equal to the stored text for the ID to break the tie
+func TestClientCursorPagesThroughTies(t *testing.T) {
+	for name, c := range testClients(t) {
+		t.Run(name, func(t *testing.T) {
+			user := createTestUser(t, c, "a@example.com")
+			for _, title := range []string{"a", "b", "c", "d"} {
+				createTestVideo(t, c, user.ID, title)
+			}
+
+			for _, sort := range []VideoSort{VideoSortCreated, VideoSortUpdated, VideoSortTitle, VideoSortDuration} {
+				params := GetVideosParams{UserID: user.ID, Sort: sort, Descending: true}
+				all, err := c.GetVideos(params)
+				if err != nil {
+					t.Fatalf("GetVideos: %v", err)
+				}
+
+				paged := []Video{}
+				params.Limit = 1
+				for range all {
+					page, err := c.GetVideos(params)
+					if err != nil {
+						t.Fatalf("GetVideos page: %v", err)
+					}
+					if len(page) != 1 {
+						t.Fatalf("%s: page %d has %d videos, want 1", sort, len(paged), len(page))
+					}
+					paged = append(paged, page[0])
+					cursor := NewVideoCursor(page[0], sort, true)
+					params.After = &cursor
+				}
+				for i := range all {
+					if paged[i].ID != all[i].ID {
+						t.Errorf("%s: page %d is %s, want %s", sort, i, paged[i].ID, all[i].ID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestClientMigrateDownAndUp(t *testing.T) {
+	for name, c := range testClients(t) {
+		t.Run(name, func(t *testing.T) {
+			reverted, err := c.MigrateDown(len(migrations))
+			if err != nil {
+				t.Fatalf("MigrateDown: %v", err)
+			}
+			if len(reverted) != len(migrations) {
+				t.Errorf("reverted %d migrations, want %d", len(reverted), len(migrations))
+			}
+
+			applied, err := c.MigrateUp()
+			if err != nil {
+				t.Fatalf("MigrateUp: %v", err)
+			}
+			if len(applied) != len(migrations) {
+				t.Errorf("applied %d migrations, want %d", len(applied), len(migrations))
+			}
+
+			statuses, err := c.MigrationStatuses()
+			if err != nil {
+				t.Fatalf("MigrationStatuses: %v", err)
+			}
+			for _, status := range statuses {
+				if status.AppliedAt == nil {
+					t.Errorf("migration %d %s is not applied", status.Version, status.Name)
+				}
+			}
+		})
+	}
+}
+
+// Rows written under the schema of migration 4 are carried over: the
+// foreign keys gain their ON DELETE behaviour (migration 5, a rebuild on
+// SQLite and postgresOnDeleteSQL on Postgres) and orientation is filled in
+// from both forms of video_url (migrations 6 and 8)
+func TestClientMigrationsFromVersion4(t *testing.T) {
+	for name, c := range testClients(t) {
+		t.Run(name, func(t *testing.T) {
+			steps := 0
+			for _, m := range migrations {
+				if m.Version > 4 {
+					steps++
+				}
+			}
+			if _, err := c.MigrateDown(steps); err != nil {
+				t.Fatalf("MigrateDown: %v", err)
+			}
+
+			user := createTestUser(t, c, "a@example.com")
+			_, err := c.CreateRefreshToken(CreateRefreshTokenParams{Token: "token", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+			if err != nil {
+				t.Fatalf("CreateRefreshToken: %v", err)
+			}
+			refVideo, urlVideo := uuid.New(), uuid.New()
+			_, err = c.exec("INSERT INTO videos (id, title, description, user_id, video_url) VALUES (?, ?, '', ?, ?), (?, ?, '', ?, ?)",
+				refVideo, "ref", user.ID, "bucket,portrait/abc.mp4",
+				urlVideo, "url", user.ID, "https://bucket.s3.us-east-1.amazonaws.com/landscape/def.mp4")
+			if err != nil {
+				t.Fatalf("insert videos: %v", err)
+			}
+			job, err := c.CreateJob(CreateJobParams{Type: "test", Payload: "{}", MaxAttempts: 1, UserID: user.ID, VideoID: &refVideo})
+			if err != nil {
+				t.Fatalf("CreateJob: %v", err)
+			}
+
+			if _, err := c.MigrateUp(); err != nil {
+				t.Fatalf("MigrateUp: %v", err)
+			}
+
+			for id, want := range map[uuid.UUID]string{refVideo: "portrait", urlVideo: "landscape"} {
+				video, err := c.GetVideo(id)
+				if err != nil {
+					t.Fatalf("GetVideo: %v", err)
+				}
+				if video.UserID != user.ID {
+					t.Errorf("video %s belongs to %s, want %s", id, video.UserID, user.ID)
+				}
+				if video.Metadata.Orientation == nil || *video.Metadata.Orientation != want {
+					t.Errorf("video %s orientation = %v, want %s", id, video.Metadata.Orientation, want)
+				}
+			}
+
+			if err := c.DeleteVideo(refVideo); err != nil {
+				t.Fatalf("DeleteVideo: %v", err)
+			}
+			job, err = c.GetJob(job.ID)
+			if err != nil {
+				t.Fatalf("GetJob: %v", err)
+			}
+			if job.VideoID != nil {
+				t.Errorf("job video = %s after the video was deleted, want null", job.VideoID)
+			}
+
+			if err := c.DeleteUser(user.ID); err != nil {
+				t.Fatalf("DeleteUser: %v", err)
+			}
+			if _, err := c.GetVideo(urlVideo); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetVideo after DeleteUser = %v, want ErrNotFound", err)
+			}
+			if _, err := c.GetRefreshToken("token"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetRefreshToken after DeleteUser = %v, want ErrNotFound", err)
+			}
+			if _, err := c.GetJob(job.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetJob after DeleteUser = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
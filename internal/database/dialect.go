@@ -0,0 +1,177 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// dialect covers the differences between the supported databases. Queries
+// are written with ? placeholders and schema SQL with {{type}} placeholders,
+// both are rewritten for the dialect before they run.
+type dialect struct {
+	name   string
+	driver string
+	// numbered placeholders, $1, $2, ...
+	numberedPlaceholders bool
+	// types replaces {{name}} in schema SQL
+	types *strings.Replacer
+	// lockForClaim is appended to the query claiming a job, so that
+	// concurrent workers skip rows another transaction holds
+	lockForClaim string
+	// lockForUpdate is appended to a read whose row the transaction goes on
+	// to update. SQLite needs none, its transactions take the write lock
+	// when they begin.
+	lockForUpdate string
+}
+
+const (
+	dialectSQLite   = "sqlite"
+	dialectPostgres = "postgres"
+)
+
+var sqliteDialect = dialect{
+	name:   dialectSQLite,
+	driver: "sqlite3",
+	types: strings.NewReplacer(
+		"{{uuid}}", "TEXT",
+		"{{timestamp}}", "TIMESTAMP",
+		"{{float}}", "REAL",
+		"{{bigint}}", "INTEGER",
+		// the SQLite schema shipped with videos.user_id as INTEGER,
+		// migration 5 fixes it
+		"{{v1_user_id}}", "INTEGER",
+	),
+}
+
+var postgresDialect = dialect{
+	name:                 dialectPostgres,
+	driver:               "postgres",
+	numberedPlaceholders: true,
+	types: strings.NewReplacer(
+		"{{uuid}}", "UUID",
+		"{{timestamp}}", "TIMESTAMPTZ",
+		"{{float}}", "DOUBLE PRECISION",
+		"{{bigint}}", "BIGINT",
+		"{{v1_user_id}}", "UUID",
+	),
+	lockForClaim:  "FOR UPDATE SKIP LOCKED",
+	lockForUpdate: "FOR UPDATE",
+}
+
+// dialectForURL picks the dialect from the scheme of a database URL and
+// returns the data source name to open. postgres:// and postgresql:// URLs
+// go to Postgres, sqlite:// URLs and plain paths to SQLite.
+func dialectForURL(dbURL string) (dialect, string, error) {
+	scheme, rest, ok := strings.Cut(dbURL, "://")
+	if !ok {
+		return sqliteDialect, sqliteDSN(dbURL), nil
+	}
+	switch scheme {
+	case "postgres", "postgresql":
+		return postgresDialect, dbURL, nil
+	case "sqlite", "sqlite3":
+		return sqliteDialect, sqliteDSN(rest), nil
+	default:
+		return dialect{}, "", fmt.Errorf("unsupported database scheme %q", scheme)
+	}
+}
+
+// sqliteDSNParams turns on foreign key enforcement, which SQLite leaves off,
+// and makes transactions take the write lock when they begin. A deferred
+// transaction that reads before it writes, like ClaimNextJob, fails with
+// SQLITE_BUSY instead of waiting when another connection wrote in between.
+var sqliteDSNParams = []string{"_foreign_keys=on", "_txlock=immediate", "_busy_timeout=5000"}
+
+// sqliteDSN adds sqliteDSNParams to path, keeping any the path already sets
+func sqliteDSN(path string) string {
+	_, query, _ := strings.Cut(path, "?")
+	for _, param := range sqliteDSNParams {
+		name, _, _ := strings.Cut(param, "=")
+		if strings.Contains("&"+query, "&"+name+"=") {
+			continue
+		}
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		path += sep + param
+	}
+	return path
+}
+
+// rebind rewrites ? placeholders outside string literals for the dialect
+func (d dialect) rebind(query string) string {
+	if !d.numberedPlaceholders || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// schema fills in the column types of schema SQL
+func (d dialect) schema(statements string) string {
+	return d.types.Replace(statements)
+}
+
+// timestamp returns t as an argument comparable with CURRENT_TIMESTAMP
+// columns. SQLite keeps those as UTC text with second precision and
+// compares them as text, so t has to be in the same format.
+func (d dialect) timestamp(t time.Time) any {
+	if d.name == dialectSQLite {
+		return t.UTC().Format(time.DateTime)
+	}
+	return t
+}
+
+func (c Client) exec(query string, args ...any) (sql.Result, error) {
+	res, err := c.db.Exec(c.dialect.rebind(query), args...)
+	return res, translateError(err)
+}
+
+func (c Client) query(query string, args ...any) (*sql.Rows, error) {
+	return c.db.Query(c.dialect.rebind(query), args...)
+}
+
+func (c Client) queryRow(query string, args ...any) *sql.Row {
+	return c.db.QueryRow(c.dialect.rebind(query), args...)
+}
+
+// txn is a transaction that rewrites queries and schema SQL for the
+// client's dialect
+type txn struct {
+	tx      *sql.Tx
+	dialect dialect
+}
+
+func (t txn) exec(query string, args ...any) (sql.Result, error) {
+	res, err := t.tx.Exec(t.dialect.rebind(t.dialect.schema(query)), args...)
+	return res, translateError(err)
+}
+
+func (t txn) query(query string, args ...any) (*sql.Rows, error) {
+	return t.tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t txn) queryRow(query string, args ...any) *sql.Row {
+	return t.tx.QueryRow(t.dialect.rebind(query), args...)
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect dialect
+		query   string
+		want    string
+	}{
+		{"sqlite unchanged", sqliteDialect, "SELECT * FROM videos WHERE id = ? AND user_id = ?", "SELECT * FROM videos WHERE id = ? AND user_id = ?"},
+		{"postgres numbered", postgresDialect, "SELECT * FROM videos WHERE id = ? AND user_id = ?", "SELECT * FROM videos WHERE id = $1 AND user_id = $2"},
+		{"postgres literal", postgresDialect, "UPDATE videos SET title = '?' WHERE id = ?", "UPDATE videos SET title = '?' WHERE id = $1"},
+		{"postgres escaped quote", postgresDialect, "SELECT 'it''s ?', ?", "SELECT 'it''s ?', $1"},
+		{"postgres row value", postgresDialect, "WHERE (created_at, id) < (?, ?) LIMIT ?", "WHERE (created_at, id) < ($1, $2) LIMIT $3"},
+		{"postgres none", postgresDialect, "DELETE FROM jobs", "DELETE FROM jobs"},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.rebind(tt.query); got != tt.want {
+			t.Errorf("%s: rebind(%q) = %q, want %q", tt.name, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	at := time.Date(2024, 1, 31, 22, 30, 15, 500_000_000, time.FixedZone("UTC-5", -5*60*60))
+
+	if got := sqliteDialect.timestamp(at); got != "2024-02-01 03:30:15" {
+		t.Errorf("sqlite timestamp = %v, want UTC text to the second", got)
+	}
+	if got := postgresDialect.timestamp(at); got != at {
+		t.Errorf("postgres timestamp = %v, want the time unchanged", got)
+	}
+}
+
+func TestDialectForURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		dialect string
+		dsn     string
+	}{
+		{"tubely.db", dialectSQLite, "tubely.db?_foreign_keys=on&_txlock=immediate&_busy_timeout=5000"},
+		{"sqlite://tubely.db?cache=shared", dialectSQLite, "tubely.db?cache=shared&_foreign_keys=on&_txlock=immediate&_busy_timeout=5000"},
+		{"sqlite://tubely.db?_busy_timeout=100", dialectSQLite, "tubely.db?_busy_timeout=100&_foreign_keys=on&_txlock=immediate"},
+		{"postgres://u@localhost/tubely", dialectPostgres, "postgres://u@localhost/tubely"},
+		{"postgresql://u@localhost/tubely", dialectPostgres, "postgresql://u@localhost/tubely"},
+	}
+	for _, tt := range tests {
+		d, dsn, err := dialectForURL(tt.url)
+		if err != nil {
+			t.Errorf("dialectForURL(%q): %v", tt.url, err)
+			continue
+		}
+		if d.name != tt.dialect || dsn != tt.dsn {
+			t.Errorf("dialectForURL(%q) = %s %q, want %s %q", tt.url, d.name, dsn, tt.dialect, tt.dsn)
+		}
+	}
+
+	if _, _, err := dialectForURL("mysql://localhost/tubely"); err == nil {
+		t.Error("dialectForURL accepted a mysql URL")
+	}
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrNotFound is returned by getters when no row matches and by updates
+	// and deletes of a row that doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a write would violate a unique constraint,
+	// such as a second user with the same email
+	ErrConflict = errors.New("conflict")
+)
+
+// pqUniqueViolation is the Postgres SQLSTATE for unique_violation
+const pqUniqueViolation = "23505"
+
+// translateError wraps unique constraint violations from either driver in
+// ErrConflict and leaves other errors alone
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) &&
+		(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation {
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	}
+	return err
+}
+
+// requireRow turns a write that matched no row into ErrNotFound
+func requireRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
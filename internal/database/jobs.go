@@ -0,0 +1,230 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JobStatus is the state of a queued job
+type JobStatus string
+
+const (
+	JobStatusQueued    JobStatus = "queued"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusSucceeded JobStatus = "succeeded"
+	// JobStatusDead is the dead-letter state for jobs that ran out of attempts
+	JobStatusDead JobStatus = "dead"
+)
+
+type Job struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Status    JobStatus `json:"status"`
+	Attempts  int       `json:"attempts"`
+	RunAt     time.Time `json:"run_at"`
+	LastError string    `json:"last_error"`
+	CreateJobParams
+}
+
+type CreateJobParams struct {
+	Type        string     `json:"type"`
+	Payload     string     `json:"-"`
+	MaxAttempts int        `json:"max_attempts"`
+	UserID      uuid.UUID  `json:"user_id"`
+	VideoID     *uuid.UUID `json:"video_id"`
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		type,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at,
+		last_error,
+		user_id,
+		video_id`
+
+func scanJob(row rowScanner) (Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Type,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LastError,
+		&job.UserID,
+		&job.VideoID,
+	)
+	return job, err
+}
+
+func (c Client) CreateJob(params CreateJobParams) (Job, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		type,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at,
+		user_id,
+		video_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 0, ?, ?, ?, ?)
+	`
+	_, err := c.exec(
+		query,
+		id,
+		params.Type,
+		params.Payload,
+		JobStatusQueued,
+		params.MaxAttempts,
+		time.Now().UTC(),
+		params.UserID,
+		params.VideoID,
+	)
+	if err != nil {
+		return Job{}, err
+	}
+
+	return c.GetJob(id)
+}
+
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE id = ?
+	`
+
+	job, err := scanJob(c.queryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, ErrNotFound
+		}
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// ClaimNextJob marks the oldest due job as running and returns it. ok is
+// false when no job is due.
+func (c Client) ClaimNextJob() (job Job, ok bool, err error) {
+	sqlTx, err := c.db.Begin()
+	if err != nil {
+		return Job{}, false, err
+	}
+	defer sqlTx.Rollback()
+	tx := txn{tx: sqlTx, dialect: c.dialect}
+
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE status = ? AND run_at <= ?
+	ORDER BY run_at ASC
+	LIMIT 1
+	` + c.dialect.lockForClaim
+	job, err = scanJob(tx.queryRow(query, JobStatusQueued, time.Now().UTC()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, false, nil
+		}
+		return Job{}, false, err
+	}
+
+	update := `
+	UPDATE jobs
+	SET
+		status = ?,
+		attempts = attempts + 1,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND status = ?
+	`
+	res, err := tx.exec(update, JobStatusRunning, job.ID, JobStatusQueued)
+	if err != nil {
+		return Job{}, false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		// another worker claimed it first
+		return Job{}, false, err
+	}
+	if err := sqlTx.Commit(); err != nil {
+		return Job{}, false, err
+	}
+
+	job.Status = JobStatusRunning
+	job.Attempts++
+	return job, true, nil
+}
+
+func (c Client) CompleteJob(id uuid.UUID) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		last_error = '',
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, JobStatusSucceeded, id)
+	return err
+}
+
+// RetryJob puts a failed job back in the queue to run again at runAt
+func (c Client) RetryJob(id uuid.UUID, runAt time.Time, cause error) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		run_at = ?,
+		last_error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, JobStatusQueued, runAt.UTC(), errorString(cause), id)
+	return err
+}
+
+// KillJob moves a job to the dead-letter state
+func (c Client) KillJob(id uuid.UUID, cause error) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		last_error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.exec(query, JobStatusDead, errorString(cause), id)
+	return err
+}
+
+// RequeueRunningJobs returns jobs left running by a previous process to the
+// queue, it must only be called before any worker starts
+func (c Client) RequeueRunningJobs() error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE status = ?
+	`
+	_, err := c.exec(query, JobStatusQueued, JobStatusRunning)
+	return err
+}
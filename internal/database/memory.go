@@ -0,0 +1,568 @@
+package database
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is a Store kept in memory, for tests and local experiments.
+// It enforces the same uniqueness and foreign key rules as the database
+// schema, including the ON DELETE behaviour.
+type MemoryStore struct {
+	mu        sync.Mutex
+	users     map[uuid.UUID]User
+	tokens    map[string]RefreshToken
+	videos    map[uuid.UUID]Video
+	jobs      map[uuid.UUID]Job
+	deletions map[uuid.UUID]AssetDeletion
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{}
+	s.reset()
+	return s
+}
+
+func (s *MemoryStore) reset() {
+	s.users = map[uuid.UUID]User{}
+	s.tokens = map[string]RefreshToken{}
+	s.videos = map[uuid.UUID]Video{}
+	s.jobs = map[uuid.UUID]Job{}
+	s.deletions = map[uuid.UUID]AssetDeletion{}
+}
+
+func (s *MemoryStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+	return nil
+}
+
+// currentTimestamp is the time the queries record with CURRENT_TIMESTAMP,
+// which SQLite keeps in UTC to the second
+func currentTimestamp() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func (s *MemoryStore) requireUser(id uuid.UUID) error {
+	if _, ok := s.users[id]; !ok {
+		return fmt.Errorf("user %s does not exist", id)
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetUsers() ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := []User{}
+	for _, user := range s.users {
+		// like the query, only the ID and email are filled in
+		users = append(users, User{ID: user.ID, CreateUserParams: CreateUserParams{Email: user.Email}})
+	}
+	return users, nil
+}
+
+func (s *MemoryStore) GetUserByEmail(email string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *MemoryStore) GetUserByRefreshToken(token string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rt, ok := s.tokens[token]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	user, ok := s.users[rt.UserID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) CreateUser(params CreateUserParams) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == params.Email {
+			return nil, fmt.Errorf("%w: user with email %q already exists", ErrConflict, params.Email)
+		}
+	}
+
+	now := currentTimestamp()
+	user := User{ID: uuid.New(), CreatedAt: now, UpdatedAt: now, CreateUserParams: params}
+	s.users[user.ID] = user
+	return &user, nil
+}
+
+func (s *MemoryStore) GetUser(id uuid.UUID) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) DeleteUser(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, id)
+	for token, rt := range s.tokens {
+		if rt.UserID == id {
+			delete(s.tokens, token)
+		}
+	}
+	for jobID, job := range s.jobs {
+		if job.UserID == id {
+			delete(s.jobs, jobID)
+		}
+	}
+	for videoID, video := range s.videos {
+		if video.UserID == id {
+			s.deleteVideo(videoID)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.requireUser(params.UserID); err != nil {
+		return RefreshToken{}, err
+	}
+	if _, ok := s.tokens[params.Token]; ok {
+		return RefreshToken{}, fmt.Errorf("%w: refresh token already exists", ErrConflict)
+	}
+
+	now := currentTimestamp()
+	rt := RefreshToken{CreateRefreshTokenParams: params, CreatedAt: now, UpdatedAt: now}
+	s.tokens[rt.Token] = rt
+	return rt, nil
+}
+
+func (s *MemoryStore) RevokeRefreshToken(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rt, ok := s.tokens[token]; ok {
+		now := currentTimestamp()
+		rt.RevokedAt = &now
+		s.tokens[token] = rt
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetRefreshToken(token string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rt, ok := s.tokens[token]
+	if !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	return rt, nil
+}
+
+func (s *MemoryStore) DeleteRefreshToken(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, token)
+	return nil
+}
+
+// copyVideo keeps callers from sharing the stored srcset map
+func copyVideo(video Video) Video {
+	video.ThumbnailSrcset = maps.Clone(video.ThumbnailSrcset)
+	return video
+}
+
+// sortVideos orders videos newest first, like the queries
+func sortVideos(videos []Video) {
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].CreatedAt.After(videos[j].CreatedAt)
+	})
+}
+
+func (s *MemoryStore) GetVideos(params GetVideosParams) ([]Video, error) {
+	params, err := params.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	videos := []Video{}
+	for _, video := range s.videos {
+		if video.UserID != params.UserID {
+			continue
+		}
+		if params.Status != "" && video.Status != params.Status {
+			continue
+		}
+		orientation := video.Metadata.Orientation
+		if params.Orientation != "" && (orientation == nil || *orientation != params.Orientation) {
+			continue
+		}
+		duration := video.Metadata.DurationSeconds
+		if params.MinDuration != 0 && (duration == nil || *duration < params.MinDuration.Seconds()) {
+			continue
+		}
+		if params.MaxDuration != 0 && (duration == nil || *duration > params.MaxDuration.Seconds()) {
+			continue
+		}
+		if !params.CreatedAfter.IsZero() && video.CreatedAt.Before(params.CreatedAfter) {
+			continue
+		}
+		if !params.CreatedBefore.IsZero() && !video.CreatedAt.Before(params.CreatedBefore) {
+			continue
+		}
+		videos = append(videos, copyVideo(video))
+	}
+
+	// compare orders ascending, flip it for descending listings
+	compare := func(a, b VideoCursor) int {
+		if params.Descending {
+			return b.compare(a)
+		}
+		return a.compare(b)
+	}
+	sort.Slice(videos, func(i, j int) bool {
+		a := NewVideoCursor(videos[i], params.Sort, params.Descending)
+		b := NewVideoCursor(videos[j], params.Sort, params.Descending)
+		return compare(a, b) < 0
+	})
+	if params.After != nil {
+		videos = slices.DeleteFunc(videos, func(video Video) bool {
+			return compare(NewVideoCursor(video, params.Sort, params.Descending), *params.After) <= 0
+		})
+	}
+	if params.Limit > 0 && len(videos) > params.Limit {
+		videos = videos[:params.Limit]
+	}
+	return videos, nil
+}
+
+func (s *MemoryStore) GetAllVideos() ([]Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	videos := []Video{}
+	for _, video := range s.videos {
+		videos = append(videos, copyVideo(video))
+	}
+	sortVideos(videos)
+	return videos, nil
+}
+
+func (s *MemoryStore) CreateVideo(params CreateVideoParams) (Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.requireUser(params.UserID); err != nil {
+		return Video{}, err
+	}
+
+	now := currentTimestamp()
+	video := Video{
+		ID:                uuid.New(),
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		Status:            VideoStatusDraft,
+		CreateVideoParams: params,
+	}
+	s.videos[video.ID] = video
+	return copyVideo(video), nil
+}
+
+func (s *MemoryStore) GetVideo(id uuid.UUID) (Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	video, ok := s.videos[id]
+	if !ok {
+		return Video{}, ErrNotFound
+	}
+	return copyVideo(video), nil
+}
+
+func (s *MemoryStore) UpdateVideo(video Video) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.videos[video.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := s.requireUser(video.UserID); err != nil {
+		return err
+	}
+	video.CreatedAt = current.CreatedAt
+	video.UpdatedAt = currentTimestamp()
+	s.videos[video.ID] = copyVideo(video)
+	return nil
+}
+
+func (s *MemoryStore) SetVideoThumbnail(id uuid.UUID, thumbnailURL string, srcset ThumbnailSrcset) (*string, ThumbnailSrcset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	video, ok := s.videos[id]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	previousURL, previousSrcset := video.ThumbnailURL, video.ThumbnailSrcset
+	video.ThumbnailURL = &thumbnailURL
+	video.ThumbnailSrcset = maps.Clone(srcset)
+	video.UpdatedAt = currentTimestamp()
+	s.videos[id] = video
+	return previousURL, previousSrcset, nil
+}
+
+func (s *MemoryStore) SetVideoThumbnailIfMissing(id uuid.UUID, thumbnailURL string, srcset ThumbnailSrcset) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	video, ok := s.videos[id]
+	if !ok || video.ThumbnailURL != nil {
+		return false, nil
+	}
+	video.ThumbnailURL = &thumbnailURL
+	video.ThumbnailSrcset = maps.Clone(srcset)
+	video.UpdatedAt = currentTimestamp()
+	s.videos[id] = video
+	return true, nil
+}
+
+func (s *MemoryStore) UpdateVideoStatus(id uuid.UUID, status VideoStatus, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	video, ok := s.videos[id]
+	if !ok {
+		return ErrNotFound
+	}
+	video.Status = status
+	video.FailureReason = nil
+	if status == VideoStatusFailed {
+		video.FailureReason = &reason
+	}
+	video.UpdatedAt = currentTimestamp()
+	s.videos[id] = video
+	return nil
+}
+
+func (s *MemoryStore) DeleteVideo(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.videos[id]; !ok {
+		return ErrNotFound
+	}
+	s.deleteVideo(id)
+	return nil
+}
+
+// deleteVideo removes a video and unlinks its jobs, s.mu must be held
+func (s *MemoryStore) deleteVideo(id uuid.UUID) {
+	delete(s.videos, id)
+	for jobID, job := range s.jobs {
+		if job.VideoID != nil && *job.VideoID == id {
+			job.VideoID = nil
+			s.jobs[jobID] = job
+		}
+	}
+}
+
+func (s *MemoryStore) CreateJob(params CreateJobParams) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.requireUser(params.UserID); err != nil {
+		return Job{}, err
+	}
+	if params.VideoID != nil {
+		if _, ok := s.videos[*params.VideoID]; !ok {
+			return Job{}, fmt.Errorf("video %s does not exist", *params.VideoID)
+		}
+	}
+
+	now := currentTimestamp()
+	job := Job{
+		ID:              uuid.New(),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		Status:          JobStatusQueued,
+		RunAt:           time.Now().UTC(),
+		CreateJobParams: params,
+	}
+	s.jobs[job.ID] = job
+	return job, nil
+}
+
+func (s *MemoryStore) GetJob(id uuid.UUID) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return job, nil
+}
+
+func (s *MemoryStore) ClaimNextJob() (Job, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	var next Job
+	found := false
+	for _, job := range s.jobs {
+		if job.Status != JobStatusQueued || job.RunAt.After(now) {
+			continue
+		}
+		if !found || job.RunAt.Before(next.RunAt) {
+			next, found = job, true
+		}
+	}
+	if !found {
+		return Job{}, false, nil
+	}
+
+	next.Status = JobStatusRunning
+	next.Attempts++
+	next.UpdatedAt = currentTimestamp()
+	s.jobs[next.ID] = next
+	return next, true, nil
+}
+
+// updateJob applies update to a stored job, s.mu must not be held
+func (s *MemoryStore) updateJob(id uuid.UUID, update func(job *Job)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil
+	}
+	update(&job)
+	job.UpdatedAt = currentTimestamp()
+	s.jobs[id] = job
+	return nil
+}
+
+func (s *MemoryStore) CompleteJob(id uuid.UUID) error {
+	return s.updateJob(id, func(job *Job) {
+		job.Status = JobStatusSucceeded
+		job.LastError = ""
+	})
+}
+
+func (s *MemoryStore) RetryJob(id uuid.UUID, runAt time.Time, cause error) error {
+	return s.updateJob(id, func(job *Job) {
+		job.Status = JobStatusQueued
+		job.RunAt = runAt.UTC()
+		job.LastError = errorString(cause)
+	})
+}
+
+func (s *MemoryStore) KillJob(id uuid.UUID, cause error) error {
+	return s.updateJob(id, func(job *Job) {
+		job.Status = JobStatusDead
+		job.LastError = errorString(cause)
+	})
+}
+
+func (s *MemoryStore) RequeueRunningJobs() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := currentTimestamp()
+	for id, job := range s.jobs {
+		if job.Status == JobStatusRunning {
+			job.Status = JobStatusQueued
+			job.UpdatedAt = now
+			s.jobs[id] = job
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateAssetDeletion(params CreateAssetDeletionParams, cause error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := currentTimestamp()
+	deletion := AssetDeletion{
+		ID:                        uuid.New(),
+		CreatedAt:                 now,
+		UpdatedAt:                 now,
+		Attempts:                  1,
+		LastError:                 errorString(cause),
+		CreateAssetDeletionParams: params,
+	}
+	s.deletions[deletion.ID] = deletion
+	return nil
+}
+
+func (s *MemoryStore) GetPendingAssetDeletions(maxAttempts, limit int) ([]AssetDeletion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deletions := []AssetDeletion{}
+	for _, deletion := range s.deletions {
+		if deletion.Attempts < maxAttempts {
+			deletions = append(deletions, deletion)
+		}
+	}
+	sort.Slice(deletions, func(i, j int) bool {
+		return deletions[i].UpdatedAt.Before(deletions[j].UpdatedAt)
+	})
+	if len(deletions) > limit {
+		deletions = deletions[:limit]
+	}
+	return deletions, nil
+}
+
+func (s *MemoryStore) RecordAssetDeletionFailure(id uuid.UUID, cause error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if deletion, ok := s.deletions[id]; ok {
+		deletion.Attempts++
+		deletion.LastError = errorString(cause)
+		deletion.UpdatedAt = currentTimestamp()
+		s.deletions[id] = deletion
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteAssetDeletion(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.deletions, id)
+	return nil
+}
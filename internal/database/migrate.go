@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// migration is one numbered schema change. Up and Down run inside a
+// transaction that also records the change in schema_migrations.
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx txn) error
+	Down    func(tx txn) error
+	// DisableForeignKeys turns SQLite's enforcement off while the
+	// migration runs, which it requires for rebuilding tables that others
+	// reference
+	DisableForeignKeys bool
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// execSQL returns a migration step running the given statements
+func execSQL(statements string) func(tx txn) error {
+	return func(tx txn) error {
+		_, err := tx.exec(statements)
+		return err
+	}
+}
+
+func (c Client) ensureMigrationsTable() error {
+	_, err := c.exec(c.dialect.schema(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at {{timestamp}} NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	`))
+	return err
+}
+
+// appliedMigrations returns when each applied migration version ran
+func (c Client) appliedMigrations() (map[int]time.Time, error) {
+	if err := c.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := c.query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatuses lists every known migration and when it was applied
+func (c Client) MigrationStatuses() ([]MigrationStatus, error) {
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []MigrationStatus{}
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if appliedAt, ok := applied[m.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies every pending migration in order and returns the ones
+// it applied. It stops at the first failure, which is rolled back.
+func (c Client) MigrateUp() ([]MigrationStatus, error) {
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	ran := []MigrationStatus{}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := c.runMigration(m, true); err != nil {
+			return ran, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		ran = append(ran, MigrationStatus{Version: m.Version, Name: m.Name})
+	}
+	return ran, nil
+}
+
+// MigrateDown reverts the latest steps applied migrations and returns the
+// ones it reverted
+func (c Client) MigrateDown(steps int) ([]MigrationStatus, error) {
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	reverted := []MigrationStatus{}
+	for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := c.runMigration(m, false); err != nil {
+			return reverted, fmt.Errorf("reverting migration %d %s: %w", m.Version, m.Name, err)
+		}
+		reverted = append(reverted, MigrationStatus{Version: m.Version, Name: m.Name})
+	}
+	return reverted, nil
+}
+
+// runMigration applies or reverts m in a single transaction. The pragma
+// can't change inside a transaction, so it is set on a dedicated connection
+// around it.
+func (c Client) runMigration(m migration, up bool) error {
+	ctx := context.Background()
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.DisableForeignKeys && c.dialect.name == dialectSQLite {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+
+	sqlTx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer sqlTx.Rollback()
+	tx := txn{tx: sqlTx, dialect: c.dialect}
+
+	if up {
+		err = m.Up(tx)
+		if err == nil {
+			_, err = tx.exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name)
+		}
+	} else {
+		err = m.Down(tx)
+		if err == nil {
+			_, err = tx.exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return sqlTx.Commit()
+}
@@ -0,0 +1,377 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// migrations are applied in order and never edited once released, schema
+// changes go in a new migration. The first four are idempotent so that
+// databases created before migrations were tracked pick up where they are.
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: execSQL(`
+		CREATE TABLE IF NOT EXISTS users (
+			id {{uuid}} PRIMARY KEY,
+			created_at {{timestamp}} DEFAULT CURRENT_TIMESTAMP,
+			updated_at {{timestamp}} DEFAULT CURRENT_TIMESTAMP,
+			password TEXT NOT NULL,
+			email TEXT UNIQUE NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS refresh_tokens (
+			token TEXT PRIMARY KEY,
+			created_at {{timestamp}} DEFAULT CURRENT_TIMESTAMP,
+			updated_at {{timestamp}} DEFAULT CURRENT_TIMESTAMP,
+			revoked_at {{timestamp}},
+			user_id {{uuid}} NOT NULL,
+			expires_at {{timestamp}} NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);
+		CREATE TABLE IF NOT EXISTS videos (
+			id {{uuid}} PRIMARY KEY,
+			created_at {{timestamp}} DEFAULT CURRENT_TIMESTAMP,
+			updated_at {{timestamp}} DEFAULT CURRENT_TIMESTAMP,
+			title TEXT NOT NULL,
+			description TEXT,
+			thumbnail_url TEXT,
+			video_url TEXT,
+			user_id {{v1_user_id}},
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);
+		`),
+		Down: execSQL(`
+		DROP TABLE videos;
+		DROP TABLE refresh_tokens;
+		DROP TABLE users;
+		`),
+	},
+	{
+		Version: 2,
+		Name:    "video_processing_columns",
+		Up: func(tx txn) error {
+			for _, column := range addedVideoColumns {
+				if err := addColumnIfMissing(tx, "videos", column.Name, column.Definition); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx txn) error {
+			for i := len(addedVideoColumns) - 1; i >= 0; i-- {
+				if _, err := tx.exec("ALTER TABLE videos DROP COLUMN " + addedVideoColumns[i].Name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 3,
+		Name:    "asset_deletions",
+		Up: execSQL(`
+		CREATE TABLE IF NOT EXISTS asset_deletions (
+			id {{uuid}} PRIMARY KEY,
+			created_at {{timestamp}} DEFAULT CURRENT_TIMESTAMP,
+			updated_at {{timestamp}} DEFAULT CURRENT_TIMESTAMP,
+			store TEXT NOT NULL,
+			key TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT ''
+		);
+		`),
+		Down: execSQL("DROP TABLE asset_deletions;"),
+	},
+	{
+		Version: 4,
+		Name:    "jobs",
+		Up:      execSQL(fmt.Sprintf(jobsTableSQL, "IF NOT EXISTS jobs", "") + jobsIndexSQL),
+		Down:    execSQL("DROP TABLE jobs;"),
+	},
+	{
+		// videos.user_id was declared INTEGER although user IDs are text,
+		// and nothing was cleaned up when a user or video was deleted
+		Version:            5,
+		Name:               "foreign_key_types_and_on_delete",
+		DisableForeignKeys: true,
+		Up: func(tx txn) error {
+			if tx.dialect.name == dialectPostgres {
+				return execSQL(postgresOnDeleteSQL)(tx)
+			}
+			err := rebuildTable(tx, "videos",
+				videosTableSQL("user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE"),
+				videoTableColumns(), strings.Replace(videoTableColumns(), "user_id", "CAST(user_id AS TEXT)", 1))
+			if err != nil {
+				return err
+			}
+			err = rebuildTable(tx, "refresh_tokens",
+				fmt.Sprintf(refreshTokensTableSQL, "%s", " ON DELETE CASCADE"),
+				refreshTokenTableColumns, refreshTokenTableColumns)
+			if err != nil {
+				return err
+			}
+			err = rebuildTable(tx, "jobs",
+				fmt.Sprintf(jobsTableSQL, "%s", ` ON DELETE CASCADE,
+			FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE SET NULL`),
+				jobTableColumns, jobTableColumns)
+			if err != nil {
+				return err
+			}
+			_, err = tx.exec(jobsIndexSQL)
+			return err
+		},
+		Down: func(tx txn) error {
+			if tx.dialect.name == dialectPostgres {
+				return execSQL(postgresOnDeleteDownSQL)(tx)
+			}
+			err := rebuildTable(tx, "jobs", fmt.Sprintf(jobsTableSQL, "%s", ""), jobTableColumns, jobTableColumns)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.exec(jobsIndexSQL); err != nil {
+				return err
+			}
+			err = rebuildTable(tx, "refresh_tokens", fmt.Sprintf(refreshTokensTableSQL, "%s", ""), refreshTokenTableColumns, refreshTokenTableColumns)
+			if err != nil {
+				return err
+			}
+			return rebuildTable(tx, "videos",
+				videosTableSQL("user_id INTEGER,\n\t\t\tFOREIGN KEY(user_id) REFERENCES users(id)"),
+				videoTableColumns(), videoTableColumns())
+		},
+	},
+	{
+		// listing filters by orientation and pages through a user's videos
+		// by creation time
+		Version: 6,
+		Name:    "video_orientation_and_listing_index",
+		Up: func(tx txn) error {
+			if _, err := tx.exec("ALTER TABLE videos ADD COLUMN orientation TEXT"); err != nil {
+				return err
+			}
+			// videos stored so far are keyed under their orientation
+			for _, orientation := range videoOrientations {
+				_, err := tx.exec("UPDATE videos SET orientation = ? WHERE video_url LIKE ?", orientation, "%,"+orientation+"/%")
+				if err != nil {
+					return err
+				}
+			}
+			_, err := tx.exec("CREATE INDEX videos_user_id_created_at ON videos(user_id, created_at, id);")
+			return err
+		},
+		Down: execSQL(`
+		DROP INDEX videos_user_id_created_at;
+		ALTER TABLE videos DROP COLUMN orientation;
+		`),
+	},
+	{
+		// a failed listing is recorded as its prefix and listed again on
+		// retry
+		Version: 7,
+		Name:    "asset_deletion_prefixes",
+		Up:      execSQL("ALTER TABLE asset_deletions ADD COLUMN prefix BOOLEAN NOT NULL DEFAULT FALSE;"),
+		Down:    execSQL("ALTER TABLE asset_deletions DROP COLUMN prefix;"),
+	},
+	{
+		// migration 6 only matched "bucket,key" values, older rows store
+		// the full URL of the video
+		Version: 8,
+		Name:    "video_orientation_from_urls",
+		Up: func(tx txn) error {
+			for _, orientation := range videoOrientations {
+				_, err := tx.exec("UPDATE videos SET orientation = ? WHERE orientation IS NULL AND video_url LIKE ?", orientation, "%/"+orientation+"/%")
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		// the column and its values are dropped by migration 6
+		Down: func(tx txn) error { return nil },
+	},
+}
+
+// videoOrientations are the storage key prefixes videos are uploaded under
+var videoOrientations = []string{"landscape", "portrait", "square", "ultrawide", "other"}
+
+// Postgres never had the INTEGER user_id, so migration 5 only swaps the
+// foreign keys for ones with ON DELETE behaviour
+const postgresOnDeleteSQL = `
+		ALTER TABLE videos ALTER COLUMN user_id SET NOT NULL;
+		ALTER TABLE videos
+			DROP CONSTRAINT videos_user_id_fkey,
+			ADD CONSTRAINT videos_user_id_fkey FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE;
+		ALTER TABLE refresh_tokens
+			DROP CONSTRAINT refresh_tokens_user_id_fkey,
+			ADD CONSTRAINT refresh_tokens_user_id_fkey FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE;
+		ALTER TABLE jobs
+			DROP CONSTRAINT jobs_user_id_fkey,
+			ADD CONSTRAINT jobs_user_id_fkey FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+			ADD CONSTRAINT jobs_video_id_fkey FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE SET NULL;
+`
+
+const postgresOnDeleteDownSQL = `
+		ALTER TABLE jobs
+			DROP CONSTRAINT jobs_video_id_fkey,
+			DROP CONSTRAINT jobs_user_id_fkey,
+			ADD CONSTRAINT jobs_user_id_fkey FOREIGN KEY(user_id) REFERENCES users(id);
+		ALTER TABLE refresh_tokens
+			DROP CONSTRAINT refresh_tokens_user_id_fkey,
+			ADD CONSTRAINT refresh_tokens_user_id_fkey FOREIGN KEY(user_id) REFERENCES users(id);
+		ALTER TABLE videos
+			DROP CONSTRAINT videos_user_id_fkey,
+			ADD CONSTRAINT videos_user_id_fkey FOREIGN KEY(user_id) REFERENCES users(id);
+		ALTER TABLE videos ALTER COLUMN user_id DROP NOT NULL;
+`
+
+// addedVideoColumns were added to the videos table after it was first
+// released
+var addedVideoColumns = []struct{ Name, Definition string }{
+	{"status", "TEXT NOT NULL DEFAULT 'draft'"},
+	{"failure_reason", "TEXT"},
+	{"hls_url", "TEXT"},
+	{"dash_url", "TEXT"},
+	{"preview_sprite_url", "TEXT"},
+	{"preview_vtt_url", "TEXT"},
+	{"duration_seconds", "{{float}}"},
+	{"width", "INTEGER"},
+	{"height", "INTEGER"},
+	{"rotation", "INTEGER"},
+	{"video_codec", "TEXT"},
+	{"frame_rate", "{{float}}"},
+	{"audio_codec", "TEXT"},
+	{"audio_channels", "INTEGER"},
+	{"bitrate", "{{bigint}}"},
+	{"container_format", "TEXT"},
+	{"original_url", "TEXT"},
+	{"source_media_type", "TEXT"},
+	{"thumbnail_srcset", "TEXT"},
+}
+
+// videoTableColumns lists the videos columns as of migration 2
+func videoTableColumns() string {
+	columns := []string{"id", "created_at", "updated_at", "title", "description", "thumbnail_url", "video_url", "user_id"}
+	for _, column := range addedVideoColumns {
+		columns = append(columns, column.Name)
+	}
+	return strings.Join(columns, ", ")
+}
+
+// videosTableSQL is the videos table as of migration 2 with the given
+// user_id definition, the table name is left as a %s verb
+func videosTableSQL(userIDColumn string) string {
+	var b strings.Builder
+	b.WriteString(`
+		CREATE TABLE %s (
+			id {{uuid}} PRIMARY KEY,
+			created_at {{timestamp}} DEFAULT CURRENT_TIMESTAMP,
+			updated_at {{timestamp}} DEFAULT CURRENT_TIMESTAMP,
+			title TEXT NOT NULL,
+			description TEXT,
+			thumbnail_url TEXT,
+			video_url TEXT,
+`)
+	for _, column := range addedVideoColumns {
+		fmt.Fprintf(&b, "\t\t\t%s %s,\n", column.Name, strings.ReplaceAll(column.Definition, "%", "%%"))
+	}
+	fmt.Fprintf(&b, "\t\t\t%s\n\t\t);\n", strings.ReplaceAll(userIDColumn, "%", "%%"))
+	return b.String()
+}
+
+// refreshTokensTableSQL takes the table name and the ON DELETE clause of
+// user_id
+const refreshTokensTableSQL = `
+		CREATE TABLE %s (
+			token TEXT PRIMARY KEY,
+			created_at {{timestamp}} DEFAULT CURRENT_TIMESTAMP,
+			updated_at {{timestamp}} DEFAULT CURRENT_TIMESTAMP,
+			revoked_at {{timestamp}},
+			user_id {{uuid}} NOT NULL,
+			expires_at {{timestamp}} NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id)%s
+		);
+`
+
+const refreshTokenTableColumns = "token, created_at, updated_at, revoked_at, user_id, expires_at"
+
+// jobsTableSQL takes the table name and the ON DELETE clause of user_id
+const jobsTableSQL = `
+		CREATE TABLE %s (
+			id {{uuid}} PRIMARY KEY,
+			created_at {{timestamp}} DEFAULT CURRENT_TIMESTAMP,
+			updated_at {{timestamp}} DEFAULT CURRENT_TIMESTAMP,
+			type TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			max_attempts INTEGER NOT NULL,
+			run_at {{timestamp}} NOT NULL,
+			last_error TEXT NOT NULL DEFAULT '',
+			user_id {{uuid}} NOT NULL,
+			video_id {{uuid}},
+			FOREIGN KEY(user_id) REFERENCES users(id)%s
+		);
+`
+
+const jobsIndexSQL = "CREATE INDEX IF NOT EXISTS jobs_status_run_at ON jobs(status, run_at);"
+
+const jobTableColumns = "id, created_at, updated_at, type, payload, status, attempts, max_attempts, run_at, last_error, user_id, video_id"
+
+// rebuildTable recreates table from createSQL, whose %s verb takes the
+// table name, copying every row across. SQLite can't change a column's type
+// or constraints in place.
+func rebuildTable(tx txn, table, createSQL, columns, selectExprs string) error {
+	tmp := table + "_new"
+	statements := []string{
+		fmt.Sprintf(createSQL, tmp),
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", tmp, columns, selectExprs, table),
+		"DROP TABLE " + table,
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", tmp, table),
+	}
+	for _, statement := range statements {
+		if _, err := tx.exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column to tables created by older versions
+func addColumnIfMissing(tx txn, table, column, definition string) error {
+	if tx.dialect.name == dialectPostgres {
+		_, err := tx.exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, column, definition))
+		return err
+	}
+
+	rows, err := tx.query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			columnType string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
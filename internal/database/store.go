@@ -0,0 +1,93 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserStore persists user accounts
+type UserStore interface {
+	GetUsers() ([]User, error)
+	GetUserByEmail(email string) (User, error)
+	GetUserByRefreshToken(token string) (*User, error)
+	CreateUser(params CreateUserParams) (*User, error)
+	GetUser(id uuid.UUID) (*User, error)
+	DeleteUser(id uuid.UUID) error
+}
+
+// TokenStore persists refresh tokens
+type TokenStore interface {
+	CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error)
+	RevokeRefreshToken(token string) error
+	GetRefreshToken(token string) (RefreshToken, error)
+	DeleteRefreshToken(token string) error
+}
+
+// VideoStore persists videos
+type VideoStore interface {
+	GetVideos(params GetVideosParams) ([]Video, error)
+	GetAllVideos() ([]Video, error)
+	CreateVideo(params CreateVideoParams) (Video, error)
+	GetVideo(id uuid.UUID) (Video, error)
+	UpdateVideo(video Video) error
+	SetVideoThumbnail(id uuid.UUID, thumbnailURL string, srcset ThumbnailSrcset) (previousURL *string, previousSrcset ThumbnailSrcset, err error)
+	SetVideoThumbnailIfMissing(id uuid.UUID, thumbnailURL string, srcset ThumbnailSrcset) (set bool, err error)
+	UpdateVideoStatus(id uuid.UUID, status VideoStatus, reason string) error
+	DeleteVideo(id uuid.UUID) error
+}
+
+// JobStore persists the background job queue
+type JobStore interface {
+	CreateJob(params CreateJobParams) (Job, error)
+	GetJob(id uuid.UUID) (Job, error)
+	ClaimNextJob() (job Job, ok bool, err error)
+	CompleteJob(id uuid.UUID) error
+	RetryJob(id uuid.UUID, runAt time.Time, cause error) error
+	KillJob(id uuid.UUID, cause error) error
+	RequeueRunningJobs() error
+}
+
+// AssetDeletionStore persists stored objects waiting to be deleted
+type AssetDeletionStore interface {
+	CreateAssetDeletion(params CreateAssetDeletionParams, cause error) error
+	GetPendingAssetDeletions(maxAttempts, limit int) ([]AssetDeletion, error)
+	RecordAssetDeletionFailure(id uuid.UUID, cause error) error
+	DeleteAssetDeletion(id uuid.UUID) error
+}
+
+// Store is everything the server keeps in the database
+type Store interface {
+	UserStore
+	TokenStore
+	VideoStore
+	JobStore
+	AssetDeletionStore
+	Reset() error
+}
+
+// Migrator is implemented by stores with a versioned schema
+type Migrator interface {
+	MigrateUp() ([]MigrationStatus, error)
+	MigrateDown(steps int) ([]MigrationStatus, error)
+	MigrationStatuses() ([]MigrationStatus, error)
+}
+
+var (
+	_ Store    = Client{}
+	_ Migrator = Client{}
+	_ Store    = (*MemoryStore)(nil)
+)
+
+// MemoryURL selects the in-memory store, which keeps nothing across restarts
+const MemoryURL = "memory://"
+
+// Open returns the store for dbURL: the in-memory store for MemoryURL and a
+// Client for anything NewClient accepts
+func Open(dbURL string, autoMigrate bool) (Store, error) {
+	if strings.TrimSuffix(dbURL, "/") == strings.TrimSuffix(MemoryURL, "/") {
+		return NewMemoryStore(), nil
+	}
+	return NewClient(dbURL, autoMigrate)
+}
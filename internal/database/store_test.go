@@ -0,0 +1,497 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// storeUnderTest is a Store with the precision it keeps CURRENT_TIMESTAMP
+// values in
+type storeUnderTest struct {
+	Store
+	precision time.Duration
+}
+
+// testStores returns every Store implementation, the memory store has to
+// behave like the databases
+func testStores(t *testing.T) map[string]storeUnderTest {
+	t.Helper()
+
+	stores := map[string]storeUnderTest{
+		"memory": {NewMemoryStore(), time.Second},
+	}
+	for name, c := range testClients(t) {
+		precision := time.Second
+		if name == dialectPostgres {
+			precision = time.Microsecond
+		}
+		stores[name] = storeUnderTest{c, precision}
+	}
+	return stores
+}
+
+func TestStoreUsers(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user := createTestUser(t, s, "a@example.com")
+
+			byEmail, err := s.GetUserByEmail("a@example.com")
+			if err != nil {
+				t.Fatalf("GetUserByEmail: %v", err)
+			}
+			byID, err := s.GetUser(user.ID)
+			if err != nil {
+				t.Fatalf("GetUser: %v", err)
+			}
+			if byEmail.ID != user.ID || byID.ID != user.ID || byID.Password != "hash" {
+				t.Errorf("got users %+v and %+v, want %+v", byEmail, byID, user)
+			}
+			if !byID.CreatedAt.Equal(user.CreatedAt) {
+				t.Errorf("GetUser created_at = %s, CreateUser returned %s", byID.CreatedAt, user.CreatedAt)
+			}
+
+			if _, err := s.GetUserByEmail("b@example.com"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetUserByEmail of unknown email = %v, want ErrNotFound", err)
+			}
+			if _, err := s.GetUser(uuid.New()); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetUser of unknown ID = %v, want ErrNotFound", err)
+			}
+			if _, err := s.CreateUser(CreateUserParams{Email: "a@example.com", Password: "hash"}); !errors.Is(err, ErrConflict) {
+				t.Errorf("CreateUser with taken email = %v, want ErrConflict", err)
+			}
+		})
+	}
+}
+
+func TestStoreRefreshTokens(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user := createTestUser(t, s, "a@example.com")
+			_, err := s.CreateRefreshToken(CreateRefreshTokenParams{Token: "token", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+			if err != nil {
+				t.Fatalf("CreateRefreshToken: %v", err)
+			}
+			_, err = s.CreateRefreshToken(CreateRefreshTokenParams{Token: "token", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+			if !errors.Is(err, ErrConflict) {
+				t.Errorf("CreateRefreshToken with a used token = %v, want ErrConflict", err)
+			}
+
+			owner, err := s.GetUserByRefreshToken("token")
+			if err != nil {
+				t.Fatalf("GetUserByRefreshToken: %v", err)
+			}
+			if owner.ID != user.ID {
+				t.Errorf("GetUserByRefreshToken = %s, want %s", owner.ID, user.ID)
+			}
+
+			if err := s.RevokeRefreshToken("token"); err != nil {
+				t.Fatalf("RevokeRefreshToken: %v", err)
+			}
+			rt, err := s.GetRefreshToken("token")
+			if err != nil {
+				t.Fatalf("GetRefreshToken: %v", err)
+			}
+			if rt.RevokedAt == nil || rt.UserID != user.ID {
+				t.Errorf("revoked token = %+v", rt)
+			}
+
+			if err := s.DeleteRefreshToken("token"); err != nil {
+				t.Fatalf("DeleteRefreshToken: %v", err)
+			}
+			if _, err := s.GetRefreshToken("token"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetRefreshToken after delete = %v, want ErrNotFound", err)
+			}
+			if _, err := s.GetUserByRefreshToken("token"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetUserByRefreshToken after delete = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestStoreVideoTimestamps(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user := createTestUser(t, s, "a@example.com")
+			video := createTestVideo(t, s, user.ID, "first")
+
+			got, err := s.GetVideo(video.ID)
+			if err != nil {
+				t.Fatalf("GetVideo: %v", err)
+			}
+			if !got.CreatedAt.Equal(video.CreatedAt) || !got.UpdatedAt.Equal(video.UpdatedAt) {
+				t.Errorf("GetVideo times = %s %s, CreateVideo returned %s %s", got.CreatedAt, got.UpdatedAt, video.CreatedAt, video.UpdatedAt)
+			}
+			if got.CreatedAt.Location() != time.UTC {
+				t.Errorf("created_at is in %s, want UTC", got.CreatedAt.Location())
+			}
+			if !got.CreatedAt.Equal(got.CreatedAt.Truncate(s.precision)) {
+				t.Errorf("created_at %s is more precise than %s", got.CreatedAt, s.precision)
+			}
+			if got.Status != VideoStatusDraft {
+				t.Errorf("new video status = %s, want %s", got.Status, VideoStatusDraft)
+			}
+		})
+	}
+}
+
+// createListingVideos stores videos with known titles, durations,
+// orientations and statuses for one user and a video of another user
+func createListingVideos(t *testing.T, s Store) uuid.UUID {
+	t.Helper()
+
+	user := createTestUser(t, s, "a@example.com")
+	other := createTestUser(t, s, "b@example.com")
+	createTestVideo(t, s, other.ID, "e-other")
+
+	videos := []struct {
+		title       string
+		duration    float64
+		orientation string
+		status      VideoStatus
+	}{
+		{"a-clip", 30, "landscape", VideoStatusReady},
+		{"b-film", 600, "portrait", VideoStatusReady},
+		{"c-draft", 0, "", VideoStatusDraft},
+		{"d-short", 5, "square", VideoStatusFailed},
+	}
+	for _, v := range videos {
+		video := createTestVideo(t, s, user.ID, v.title)
+		video.Status = v.status
+		if v.duration != 0 {
+			video.Metadata.DurationSeconds = &v.duration
+		}
+		if v.orientation != "" {
+			video.Metadata.Orientation = &v.orientation
+		}
+		if err := s.UpdateVideo(video); err != nil {
+			t.Fatalf("UpdateVideo: %v", err)
+		}
+	}
+	return user.ID
+}
+
+func videoTitles(videos []Video) []string {
+	titles := []string{}
+	for _, video := range videos {
+		titles = append(titles, video.Title)
+	}
+	return titles
+}
+
+func TestStoreGetVideosFilters(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			userID := createListingVideos(t, s)
+			future := time.Now().Add(time.Hour)
+
+			tests := []struct {
+				name   string
+				params GetVideosParams
+				want   []string
+			}{
+				{"all", GetVideosParams{}, []string{"a-clip", "b-film", "c-draft", "d-short"}},
+				{"status", GetVideosParams{Status: VideoStatusReady}, []string{"a-clip", "b-film"}},
+				{"orientation", GetVideosParams{Orientation: "portrait"}, []string{"b-film"}},
+				{"min duration", GetVideosParams{MinDuration: 10 * time.Second}, []string{"a-clip", "b-film"}},
+				{"max duration", GetVideosParams{MaxDuration: time.Minute}, []string{"a-clip", "d-short"}},
+				{"duration range", GetVideosParams{MinDuration: 10 * time.Second, MaxDuration: time.Minute}, []string{"a-clip"}},
+				{"created after", GetVideosParams{CreatedAfter: future}, []string{}},
+				{"created before", GetVideosParams{CreatedBefore: future}, []string{"a-clip", "b-film", "c-draft", "d-short"}},
+			}
+			for _, tt := range tests {
+				tt.params.UserID = userID
+				tt.params.Sort = VideoSortTitle
+				videos, err := s.GetVideos(tt.params)
+				if err != nil {
+					t.Fatalf("%s: GetVideos: %v", tt.name, err)
+				}
+				if got := videoTitles(videos); !slices.Equal(got, tt.want) {
+					t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreGetVideosOrder(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			userID := createListingVideos(t, s)
+
+			tests := []struct {
+				sort       VideoSort
+				descending bool
+				want       []string
+			}{
+				{VideoSortTitle, false, []string{"a-clip", "b-film", "c-draft", "d-short"}},
+				{VideoSortTitle, true, []string{"d-short", "c-draft", "b-film", "a-clip"}},
+				// an unknown duration sorts before every other
+				{VideoSortDuration, false, []string{"c-draft", "d-short", "a-clip", "b-film"}},
+				{VideoSortDuration, true, []string{"b-film", "a-clip", "d-short", "c-draft"}},
+			}
+			for _, tt := range tests {
+				videos, err := s.GetVideos(GetVideosParams{UserID: userID, Sort: tt.sort, Descending: tt.descending})
+				if err != nil {
+					t.Fatalf("GetVideos: %v", err)
+				}
+				if got := videoTitles(videos); !slices.Equal(got, tt.want) {
+					t.Errorf("%s descending=%v: got %v, want %v", tt.sort, tt.descending, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// Every sort in both directions returns videos in cursor order, and paging
+// with cursors returns the same videos as a single listing
+func TestStoreGetVideosPages(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			userID := createListingVideos(t, s)
+
+			for _, sort := range []VideoSort{VideoSortCreated, VideoSortUpdated, VideoSortTitle, VideoSortDuration} {
+				for _, descending := range []bool{false, true} {
+					params := GetVideosParams{UserID: userID, Sort: sort, Descending: descending}
+					all, err := s.GetVideos(params)
+					if err != nil {
+						t.Fatalf("GetVideos: %v", err)
+					}
+					for i := 1; i < len(all); i++ {
+						prev := NewVideoCursor(all[i-1], sort, descending)
+						next := NewVideoCursor(all[i], sort, descending)
+						if n := prev.compare(next); (descending && n <= 0) || (!descending && n >= 0) {
+							t.Errorf("%s descending=%v: %s is listed before %s", sort, descending, all[i-1].Title, all[i].Title)
+						}
+					}
+
+					paged := []Video{}
+					params.Limit = 3
+					for {
+						page, err := s.GetVideos(params)
+						if err != nil {
+							t.Fatalf("GetVideos page: %v", err)
+						}
+						paged = append(paged, page...)
+						if len(page) < params.Limit {
+							break
+						}
+						cursor := NewVideoCursor(page[len(page)-1], sort, descending)
+						params.After = &cursor
+					}
+					if got, want := videoTitles(paged), videoTitles(all); !slices.Equal(got, want) {
+						t.Errorf("%s descending=%v: pages are %v, want %v", sort, descending, got, want)
+					}
+				}
+			}
+
+			cursor := NewVideoCursor(Video{ID: uuid.New(), CreateVideoParams: CreateVideoParams{Title: "a"}}, VideoSortTitle, false)
+			_, err := s.GetVideos(GetVideosParams{UserID: userID, Sort: VideoSortCreated, After: &cursor})
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("GetVideos with a title cursor on a created listing = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestStoreDeleteUserCascades(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user := createTestUser(t, s, "a@example.com")
+			video := createTestVideo(t, s, user.ID, "first")
+			_, err := s.CreateRefreshToken(CreateRefreshTokenParams{Token: "token", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+			if err != nil {
+				t.Fatalf("CreateRefreshToken: %v", err)
+			}
+			job, err := s.CreateJob(CreateJobParams{Type: "test", Payload: "{}", MaxAttempts: 1, UserID: user.ID, VideoID: &video.ID})
+			if err != nil {
+				t.Fatalf("CreateJob: %v", err)
+			}
+
+			if err := s.DeleteUser(user.ID); err != nil {
+				t.Fatalf("DeleteUser: %v", err)
+			}
+			if _, err := s.GetVideo(video.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetVideo = %v, want ErrNotFound", err)
+			}
+			if _, err := s.GetRefreshToken("token"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetRefreshToken = %v, want ErrNotFound", err)
+			}
+			if _, err := s.GetJob(job.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetJob = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestStoreJobs(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user := createTestUser(t, s, "a@example.com")
+			video := createTestVideo(t, s, user.ID, "first")
+			created, err := s.CreateJob(CreateJobParams{Type: "test", Payload: "{}", MaxAttempts: 2, UserID: user.ID, VideoID: &video.ID})
+			if err != nil {
+				t.Fatalf("CreateJob: %v", err)
+			}
+
+			job, ok, err := s.ClaimNextJob()
+			if err != nil || !ok {
+				t.Fatalf("ClaimNextJob = %v %v", ok, err)
+			}
+			if job.ID != created.ID || job.Status != JobStatusRunning || job.Attempts != 1 {
+				t.Errorf("claimed %+v", job)
+			}
+			if _, ok, err := s.ClaimNextJob(); err != nil || ok {
+				t.Errorf("second ClaimNextJob = %v %v, want nothing to claim", ok, err)
+			}
+
+			if err := s.RetryJob(job.ID, time.Now().Add(-time.Second), errors.New("boom")); err != nil {
+				t.Fatalf("RetryJob: %v", err)
+			}
+			job, ok, err = s.ClaimNextJob()
+			if err != nil || !ok || job.Attempts != 2 || job.LastError != "boom" {
+				t.Fatalf("ClaimNextJob after retry = %+v %v %v", job, ok, err)
+			}
+
+			if err := s.DeleteVideo(video.ID); err != nil {
+				t.Fatalf("DeleteVideo: %v", err)
+			}
+			if err := s.CompleteJob(job.ID); err != nil {
+				t.Fatalf("CompleteJob: %v", err)
+			}
+			job, err = s.GetJob(job.ID)
+			if err != nil {
+				t.Fatalf("GetJob: %v", err)
+			}
+			if job.Status != JobStatusSucceeded || job.VideoID != nil {
+				t.Errorf("completed job = %s for video %v, want %s without a video", job.Status, job.VideoID, JobStatusSucceeded)
+			}
+		})
+	}
+}
+
+// Workers claim concurrently, every job has to be claimed exactly once and
+// a busy database must not surface as an error
+func TestStoreClaimNextJobConcurrently(t *testing.T) {
+	const jobs, workers = 20, 8
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user := createTestUser(t, s, "a@example.com")
+			for range jobs {
+				if _, err := s.CreateJob(CreateJobParams{Type: "test", Payload: "{}", MaxAttempts: 1, UserID: user.ID}); err != nil {
+					t.Fatalf("CreateJob: %v", err)
+				}
+			}
+
+			var (
+				mu      sync.Mutex
+				claimed = map[uuid.UUID]int{}
+				wg      sync.WaitGroup
+			)
+			for range workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						job, ok, err := s.ClaimNextJob()
+						if err != nil {
+							t.Errorf("ClaimNextJob: %v", err)
+							return
+						}
+						if !ok {
+							return
+						}
+						mu.Lock()
+						claimed[job.ID]++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+
+			if len(claimed) != jobs {
+				t.Errorf("claimed %d jobs, want %d", len(claimed), jobs)
+			}
+			for id, n := range claimed {
+				if n != 1 {
+					t.Errorf("job %s claimed %d times", id, n)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreWritesToMissingVideo(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user := createTestUser(t, s, "a@example.com")
+			video := createTestVideo(t, s, user.ID, "first")
+			if err := s.DeleteVideo(video.ID); err != nil {
+				t.Fatalf("DeleteVideo: %v", err)
+			}
+
+			if err := s.UpdateVideo(video); !errors.Is(err, ErrNotFound) {
+				t.Errorf("UpdateVideo = %v, want ErrNotFound", err)
+			}
+			if err := s.UpdateVideoStatus(video.ID, VideoStatusReady, ""); !errors.Is(err, ErrNotFound) {
+				t.Errorf("UpdateVideoStatus = %v, want ErrNotFound", err)
+			}
+			if err := s.DeleteVideo(video.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("second DeleteVideo = %v, want ErrNotFound", err)
+			}
+			if _, err := s.GetVideo(video.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("GetVideo = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestStoreSetVideoThumbnail(t *testing.T) {
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			user := createTestUser(t, s, "a@example.com")
+			video := createTestVideo(t, s, user.ID, "first")
+			videoURL := "bucket,landscape/abc.mp4"
+			video.VideoURL = &videoURL
+			video.Status = VideoStatusReady
+			if err := s.UpdateVideo(video); err != nil {
+				t.Fatalf("UpdateVideo: %v", err)
+			}
+
+			prevURL, prevSrcset, err := s.SetVideoThumbnail(video.ID, "http://localhost/a.jpg", ThumbnailSrcset{"image/webp": "http://localhost/a.webp 320w"})
+			if err != nil {
+				t.Fatalf("first SetVideoThumbnail: %v", err)
+			}
+			if prevURL != nil || prevSrcset != nil {
+				t.Errorf("first SetVideoThumbnail replaced %v %v, want nothing", prevURL, prevSrcset)
+			}
+
+			prevURL, prevSrcset, err = s.SetVideoThumbnail(video.ID, "http://localhost/b.jpg", nil)
+			if err != nil {
+				t.Fatalf("second SetVideoThumbnail: %v", err)
+			}
+			if prevURL == nil || *prevURL != "http://localhost/a.jpg" || prevSrcset["image/webp"] != "http://localhost/a.webp 320w" {
+				t.Errorf("second SetVideoThumbnail replaced %v %v, want the first thumbnail", prevURL, prevSrcset)
+			}
+
+			got, err := s.GetVideo(video.ID)
+			if err != nil {
+				t.Fatalf("GetVideo: %v", err)
+			}
+			if got.ThumbnailURL == nil || *got.ThumbnailURL != "http://localhost/b.jpg" || len(got.ThumbnailSrcset) != 0 {
+				t.Errorf("thumbnail = %v %v, want the second one", got.ThumbnailURL, got.ThumbnailSrcset)
+			}
+			if got.VideoURL == nil || *got.VideoURL != videoURL || got.Status != VideoStatusReady {
+				t.Errorf("video = %v %s, want the other fields untouched", got.VideoURL, got.Status)
+			}
+
+			if _, _, err := s.SetVideoThumbnail(uuid.New(), "http://localhost/c.jpg", nil); !errors.Is(err, ErrNotFound) {
+				t.Errorf("SetVideoThumbnail of unknown video = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoSort is the field GetVideos orders by, ties are broken by ID
+type VideoSort string
+
+const (
+	VideoSortCreated  VideoSort = "created"
+	VideoSortUpdated  VideoSort = "updated"
+	VideoSortTitle    VideoSort = "title"
+	VideoSortDuration VideoSort = "duration"
+)
+
+// Valid reports whether s is one of the known sorts
+func (s VideoSort) Valid() bool {
+	switch s {
+	case VideoSortCreated, VideoSortUpdated, VideoSortTitle, VideoSortDuration:
+		return true
+	}
+	return false
+}
+
+// column is the SQL expression s orders by. Videos without a known
+// duration sort as if it were -1, before every other video.
+func (s VideoSort) column() string {
+	switch s {
+	case VideoSortUpdated:
+		return "updated_at"
+	case VideoSortTitle:
+		return "title"
+	case VideoSortDuration:
+		return "COALESCE(duration_seconds, -1)"
+	default:
+		return "created_at"
+	}
+}
+
+// VideoCursor is the position of a video in a sorted listing, GetVideos
+// continues after it. Only the field for Sort is set.
+type VideoCursor struct {
+	Sort       VideoSort `json:"s"`
+	Descending bool      `json:"d,omitempty"`
+	Time       time.Time `json:"t"`
+	Title      string    `json:"n,omitempty"`
+	Duration   float64   `json:"l,omitempty"`
+	ID         uuid.UUID `json:"i"`
+}
+
+// ErrInvalidCursor is returned by ParseVideoCursor for cursors it didn't
+// issue
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// NewVideoCursor returns the position of video in a listing ordered by sort
+func NewVideoCursor(video Video, sort VideoSort, descending bool) VideoCursor {
+	cursor := VideoCursor{Sort: sort, Descending: descending, ID: video.ID}
+	switch sort {
+	case VideoSortUpdated:
+		cursor.Time = video.UpdatedAt
+	case VideoSortTitle:
+		cursor.Title = video.Title
+	case VideoSortDuration:
+		cursor.Duration = -1
+		if video.Metadata.DurationSeconds != nil {
+			cursor.Duration = *video.Metadata.DurationSeconds
+		}
+	default:
+		cursor.Time = video.CreatedAt
+	}
+	return cursor
+}
+
+// Encode returns the cursor as an opaque URL-safe string
+func (c VideoCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseVideoCursor reverses Encode
+func ParseVideoCursor(s string) (VideoCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return VideoCursor{}, ErrInvalidCursor
+	}
+	var cursor VideoCursor
+	if err := json.Unmarshal(b, &cursor); err != nil || !cursor.Sort.Valid() || cursor.ID == uuid.Nil {
+		return VideoCursor{}, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// value is the sort key of the cursor as a query argument
+func (c VideoCursor) value(d dialect) any {
+	switch c.Sort {
+	case VideoSortTitle:
+		return c.Title
+	case VideoSortDuration:
+		return c.Duration
+	default:
+		return d.timestamp(c.Time)
+	}
+}
+
+// compare orders two cursors of the same sort ascending, the way the
+// queries do
+func (c VideoCursor) compare(o VideoCursor) int {
+	var n int
+	switch c.Sort {
+	case VideoSortTitle:
+		n = strings.Compare(c.Title, o.Title)
+	case VideoSortDuration:
+		n = cmp.Compare(c.Duration, o.Duration)
+	default:
+		n = c.Time.Compare(o.Time)
+	}
+	if n != 0 {
+		return n
+	}
+	return strings.Compare(c.ID.String(), o.ID.String())
+}
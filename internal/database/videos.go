@@ -2,68 +2,307 @@ package database
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// VideoStatus is the processing state of a video
+type VideoStatus string
+
+const (
+	VideoStatusDraft      VideoStatus = "draft"
+	VideoStatusUploading  VideoStatus = "uploading"
+	VideoStatusProcessing VideoStatus = "processing"
+	VideoStatusReady      VideoStatus = "ready"
+	VideoStatusFailed     VideoStatus = "failed"
+)
+
+// Valid reports whether s is one of the known statuses
+func (s VideoStatus) Valid() bool {
+	switch s {
+	case VideoStatusDraft, VideoStatusUploading, VideoStatusProcessing, VideoStatusReady, VideoStatusFailed:
+		return true
+	}
+	return false
+}
+
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID               uuid.UUID       `json:"id"`
+	CreatedAt        time.Time       `json:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
+	ThumbnailURL     *string         `json:"thumbnail_url"`
+	ThumbnailSrcset  ThumbnailSrcset `json:"thumbnail_srcset"`
+	VideoURL         *string         `json:"video_url"`
+	OriginalURL      *string         `json:"original_url"`
+	HLSURL           *string         `json:"hls_url"`
+	DASHURL          *string         `json:"dash_url"`
+	PreviewSpriteURL *string         `json:"preview_sprite_url"`
+	PreviewVTTURL    *string         `json:"preview_vtt_url"`
+	Status           VideoStatus     `json:"status"`
+	FailureReason    *string         `json:"failure_reason"`
+	Metadata         MediaMetadata   `json:"metadata"`
 	CreateVideoParams
 }
 
+// ThumbnailSrcset maps each encoded media type of a thumbnail to a srcset
+// attribute listing its width variants, stored as JSON
+type ThumbnailSrcset map[string]string
+
+func (s ThumbnailSrcset) Value() (driver.Value, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(s)
+	return string(b), err
+}
+
+func (s *ThumbnailSrcset) Scan(src any) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("can't scan %T into ThumbnailSrcset", src)
+	}
+	return json.Unmarshal(b, s)
+}
+
+// MediaMetadata is the technical metadata of the uploaded file, fields are
+// nil until the video has been processed or when the file doesn't have them
+type MediaMetadata struct {
+	DurationSeconds *float64 `json:"duration_seconds"`
+	Width           *int     `json:"width"`
+	Height          *int     `json:"height"`
+	Rotation        *int     `json:"rotation"`
+	VideoCodec      *string  `json:"video_codec"`
+	FrameRate       *float64 `json:"frame_rate"`
+	AudioCodec      *string  `json:"audio_codec"`
+	AudioChannels   *int     `json:"audio_channels"`
+	Bitrate         *int64   `json:"bitrate"`
+	ContainerFormat *string  `json:"container_format"`
+	// SourceMediaType is the media type detected from the uploaded bytes
+	SourceMediaType *string `json:"source_media_type"`
+	// Orientation is the shape the video is displayed in, which is also
+	// the prefix of its storage key
+	Orientation *string `json:"orientation"`
+}
+
 type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+// GetVideosParams filters, orders and pages the videos returned by
+// GetVideos
+type GetVideosParams struct {
+	UserID uuid.UUID
+	// Status is optional, the zero value matches every status
+	Status VideoStatus
+	// Orientation is optional, the zero value matches every orientation
+	Orientation string
+	// MinDuration and MaxDuration are optional bounds on the duration,
+	// videos without a known duration only match when both are zero
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	// CreatedAfter and CreatedBefore are optional bounds on the creation
+	// time, inclusive and exclusive respectively
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Sort defaults to VideoSortCreated
+	Sort       VideoSort
+	Descending bool
+	// After is optional, only videos after the cursor are returned. Its
+	// sort and direction must match the params.
+	After *VideoCursor
+	// Limit is optional, zero returns every video
+	Limit int
+}
+
+// normalize applies the defaults every store uses and checks the cursor
+// belongs to the listing. Creation time bounds are cut to the second,
+// the precision SQLite keeps timestamps in.
+func (p GetVideosParams) normalize() (GetVideosParams, error) {
+	if p.Sort == "" {
+		p.Sort = VideoSortCreated
+	}
+	if p.After != nil && (p.After.Sort != p.Sort || p.After.Descending != p.Descending) {
+		return p, ErrInvalidCursor
+	}
+	p.CreatedAfter = p.CreatedAfter.Truncate(time.Second)
+	p.CreatedBefore = p.CreatedBefore.Truncate(time.Second)
+	return p, nil
+}
+
+// videoColumns is the column list scanned by scanVideo
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
 		title,
 		description,
 		thumbnail_url,
+		thumbnail_srcset,
 		video_url,
-		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+		original_url,
+		hls_url,
+		dash_url,
+		preview_sprite_url,
+		preview_vtt_url,
+		user_id,
+		status,
+		failure_reason,
+		duration_seconds,
+		width,
+		height,
+		rotation,
+		video_codec,
+		frame_rate,
+		audio_codec,
+		audio_channels,
+		bitrate,
+		container_format,
+		source_media_type,
+		orientation`
 
-	rows, err := c.db.Query(query, userID)
-	if err != nil {
-		return nil, err
-	}
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.ThumbnailSrcset,
+		&video.VideoURL,
+		&video.OriginalURL,
+		&video.HLSURL,
+		&video.DASHURL,
+		&video.PreviewSpriteURL,
+		&video.PreviewVTTURL,
+		&video.UserID,
+		&video.Status,
+		&video.FailureReason,
+		&video.Metadata.DurationSeconds,
+		&video.Metadata.Width,
+		&video.Metadata.Height,
+		&video.Metadata.Rotation,
+		&video.Metadata.VideoCodec,
+		&video.Metadata.FrameRate,
+		&video.Metadata.AudioCodec,
+		&video.Metadata.AudioChannels,
+		&video.Metadata.Bitrate,
+		&video.Metadata.ContainerFormat,
+		&video.Metadata.SourceMediaType,
+		&video.Metadata.Orientation,
+	)
+	return video, err
+}
+
+func scanVideos(rows *sql.Rows) ([]Video, error) {
 	defer rows.Close()
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, rows.Err()
+}
+
+func (c Client) GetVideos(params GetVideosParams) ([]Video, error) {
+	params, err := params.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := []string{"user_id = ?"}
+	args := []any{params.UserID}
+	where := func(condition string, conditionArgs ...any) {
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+
+	if params.Status != "" {
+		where("status = ?", params.Status)
+	}
+	if params.Orientation != "" {
+		where("orientation = ?", params.Orientation)
+	}
+	if params.MinDuration != 0 {
+		where("duration_seconds >= ?", params.MinDuration.Seconds())
+	}
+	if params.MaxDuration != 0 {
+		where("duration_seconds <= ?", params.MaxDuration.Seconds())
+	}
+	if !params.CreatedAfter.IsZero() {
+		where("created_at >= ?", c.dialect.timestamp(params.CreatedAfter))
+	}
+	if !params.CreatedBefore.IsZero() {
+		where("created_at < ?", c.dialect.timestamp(params.CreatedBefore))
+	}
+
+	sortColumn := params.Sort.column()
+	direction, after := "ASC", ">"
+	if params.Descending {
+		direction, after = "DESC", "<"
+	}
+	if params.After != nil {
+		where(fmt.Sprintf("(%s, id) %s (?, ?)", sortColumn, after), params.After.value(c.dialect), params.After.ID)
+	}
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE ` + strings.Join(conditions, "\n\tAND ") + fmt.Sprintf(`
+	ORDER BY %s %s, id %s
+	`, sortColumn, direction, direction)
+	if params.Limit > 0 {
+		query += "LIMIT ?\n"
+		args = append(args, params.Limit)
+	}
+
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
+
+// GetAllVideos returns the videos of every user, it is used by maintenance
+// jobs that need to know which stored assets are still referenced
+func (c Client) GetAllVideos() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.query(query)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -75,10 +314,11 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		status
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.exec(query, id, params.Title, params.Description, params.UserID, VideoStatusDraft)
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,32 +328,15 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.queryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return Video{}, nil
+			return Video{}, ErrNotFound
 		}
 		return Video{}, err
 	}
@@ -128,21 +351,149 @@ func (c Client) UpdateVideo(video Video) error {
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
+		thumbnail_srcset = ?,
 		video_url = ?,
-		user_id = ?
+		original_url = ?,
+		hls_url = ?,
+		dash_url = ?,
+		preview_sprite_url = ?,
+		preview_vtt_url = ?,
+		user_id = ?,
+		status = ?,
+		failure_reason = ?,
+		duration_seconds = ?,
+		width = ?,
+		height = ?,
+		rotation = ?,
+		video_codec = ?,
+		frame_rate = ?,
+		audio_codec = ?,
+		audio_channels = ?,
+		bitrate = ?,
+		container_format = ?,
+		source_media_type = ?,
+		orientation = ?,
+		updated_at = CURRENT_TIMESTAMP
 	WHERE id = ?
 	`
 
-	_, err := c.db.Exec(
+	res, err := c.exec(
 		query,
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
+		video.ThumbnailSrcset,
 		&video.VideoURL,
+		video.OriginalURL,
+		video.HLSURL,
+		video.DASHURL,
+		video.PreviewSpriteURL,
+		video.PreviewVTTURL,
 		video.UserID,
+		video.Status,
+		video.FailureReason,
+		video.Metadata.DurationSeconds,
+		video.Metadata.Width,
+		video.Metadata.Height,
+		video.Metadata.Rotation,
+		video.Metadata.VideoCodec,
+		video.Metadata.FrameRate,
+		video.Metadata.AudioCodec,
+		video.Metadata.AudioChannels,
+		video.Metadata.Bitrate,
+		video.Metadata.ContainerFormat,
+		video.Metadata.SourceMediaType,
+		video.Metadata.Orientation,
 		video.ID,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+// SetVideoThumbnailIfMissing sets the thumbnail of a video that doesn't
+// have one yet, set is false when the video already had a thumbnail
+func (c Client) SetVideoThumbnailIfMissing(id uuid.UUID, thumbnailURL string, srcset ThumbnailSrcset) (set bool, err error) {
+	query := `
+	UPDATE videos
+	SET
+		thumbnail_url = ?,
+		thumbnail_srcset = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND thumbnail_url IS NULL
+	`
+
+	res, err := c.exec(query, thumbnailURL, srcset, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetVideoThumbnail replaces the thumbnail of a video without touching its
+// other fields and returns the thumbnail it replaced, so that the caller can
+// delete its files
+func (c Client) SetVideoThumbnail(id uuid.UUID, thumbnailURL string, srcset ThumbnailSrcset) (previousURL *string, previousSrcset ThumbnailSrcset, err error) {
+	sqlTx, err := c.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer sqlTx.Rollback()
+	tx := txn{tx: sqlTx, dialect: c.dialect}
+
+	query := `
+	SELECT thumbnail_url, thumbnail_srcset
+	FROM videos
+	WHERE id = ?
+	` + c.dialect.lockForUpdate
+	err = tx.queryRow(query, id).Scan(&previousURL, &previousSrcset)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+
+	update := `
+	UPDATE videos
+	SET
+		thumbnail_url = ?,
+		thumbnail_srcset = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	if _, err := tx.exec(update, thumbnailURL, srcset, id); err != nil {
+		return nil, nil, err
+	}
+	if err := sqlTx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return previousURL, previousSrcset, nil
+}
+
+// UpdateVideoStatus moves a video to status without touching its other
+// fields. reason is stored for failed videos and cleared otherwise.
+func (c Client) UpdateVideoStatus(id uuid.UUID, status VideoStatus, reason string) error {
+	query := `
+	UPDATE videos
+	SET
+		status = ?,
+		failure_reason = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+
+	var failureReason *string
+	if status == VideoStatusFailed {
+		failureReason = &reason
+	}
+	res, err := c.exec(query, status, failureReason, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
 }
 
 func (c Client) DeleteVideo(id uuid.UUID) error {
@@ -150,6 +501,9 @@ func (c Client) DeleteVideo(id uuid.UUID) error {
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
-	return err
+	res, err := c.exec(query, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
 }
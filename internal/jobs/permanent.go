@@ -0,0 +1,22 @@
+package jobs
+
+import "errors"
+
+// permanentError marks a job failure that retrying can't fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the job fails immediately instead of being retried
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether err was wrapped with Permanent
+func IsPermanent(err error) bool {
+	var perm permanentError
+	return errors.As(err, &perm)
+}
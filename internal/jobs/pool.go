@@ -0,0 +1,171 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Handler runs a single job, returning an error schedules a retry unless
+// it is wrapped with Permanent
+type Handler func(ctx context.Context, job database.Job) error
+
+// Options configures a worker pool
+type Options struct {
+	Workers      int
+	PollInterval time.Duration
+	// RetryBackoff is the delay before the first retry, it doubles with
+	// every further attempt
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Workers <= 0 {
+		o.Workers = 2
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = 2 * time.Second
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = 30 * time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = time.Hour
+	}
+	return o
+}
+
+// Pool runs queued jobs on a fixed number of workers
+type Pool struct {
+	db       database.JobStore
+	opts     Options
+	handlers map[string]Handler
+	wake     chan struct{}
+}
+
+func NewPool(db database.JobStore, opts Options) *Pool {
+	return &Pool{
+		db:       db,
+		opts:     opts.withDefaults(),
+		handlers: map[string]Handler{},
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Register sets the handler for a job type, it must be called before Start
+func (p *Pool) Register(jobType string, h Handler) {
+	p.handlers[jobType] = h
+}
+
+// Enqueue stores a job and wakes an idle worker
+func (p *Pool) Enqueue(params database.CreateJobParams) (database.Job, error) {
+	if _, ok := p.handlers[params.Type]; !ok {
+		return database.Job{}, fmt.Errorf("no handler for job type %q", params.Type)
+	}
+	job, err := p.db.CreateJob(params)
+	if err != nil {
+		return database.Job{}, err
+	}
+	p.notify()
+	return job, nil
+}
+
+func (p *Pool) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start requeues jobs interrupted by a previous shutdown and starts the
+// workers. The workers stop once ctx is cancelled.
+func (p *Pool) Start(ctx context.Context) error {
+	if err := p.db.RequeueRunningJobs(); err != nil {
+		return err
+	}
+
+	for i := 0; i < p.opts.Workers; i++ {
+		go p.work(ctx)
+	}
+	return nil
+}
+
+func (p *Pool) work(ctx context.Context) {
+	ticker := time.NewTicker(p.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for p.runNext(ctx) {
+			if ctx.Err() != nil {
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNext claims and runs one due job, reporting whether there was one
+func (p *Pool) runNext(ctx context.Context) bool {
+	job, ok, err := p.db.ClaimNextJob()
+	if err != nil {
+		log.Printf("Couldn't claim job: %v", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+
+	err = p.run(ctx, job)
+	if err == nil {
+		if err := p.db.CompleteJob(job.ID); err != nil {
+			log.Printf("Couldn't complete job %s: %v", job.ID, err)
+		}
+		return true
+	}
+
+	if job.Attempts >= job.MaxAttempts || IsPermanent(err) {
+		log.Printf("Job %s (%s) failed permanently after %d attempts: %v", job.ID, job.Type, job.Attempts, err)
+		if err := p.db.KillJob(job.ID, err); err != nil {
+			log.Printf("Couldn't move job %s to dead letter: %v", job.ID, err)
+		}
+		return true
+	}
+
+	delay := p.backoff(job.Attempts)
+	log.Printf("Job %s (%s) failed, retrying in %s: %v", job.ID, job.Type, delay, err)
+	if err := p.db.RetryJob(job.ID, time.Now().Add(delay), err); err != nil {
+		log.Printf("Couldn't reschedule job %s: %v", job.ID, err)
+	}
+	return true
+}
+
+func (p *Pool) run(ctx context.Context, job database.Job) (err error) {
+	h, ok := p.handlers[job.Type]
+	if !ok {
+		return fmt.Errorf("no handler for job type %q", job.Type)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
+
+func (p *Pool) backoff(attempt int) time.Duration {
+	delay := p.opts.RetryBackoff
+	for i := 1; i < attempt && delay < p.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, p.opts.MaxBackoff)
+}
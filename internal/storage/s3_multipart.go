@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// MinPartSize is the smallest part size S3 accepts for all but the last part
+const MinPartSize = 5 << 20
+
+// MultipartOptions controls how large objects are uploaded to S3
+type MultipartOptions struct {
+	PartSize    int64
+	Concurrency int
+	MaxRetries  int
+}
+
+// DefaultMultipartOptions returns the options used when none are configured
+func DefaultMultipartOptions() MultipartOptions {
+	return MultipartOptions{
+		PartSize:    16 << 20,
+		Concurrency: 4,
+		MaxRetries:  3,
+	}
+}
+
+func (o MultipartOptions) withDefaults() MultipartOptions {
+	def := DefaultMultipartOptions()
+	if o.PartSize < MinPartSize {
+		o.PartSize = def.PartSize
+	}
+	if o.Concurrency < 1 {
+		o.Concurrency = def.Concurrency
+	}
+	if o.MaxRetries < 0 {
+		o.MaxRetries = def.MaxRetries
+	}
+	return o
+}
+
+type uploadPart struct {
+	number int32
+	data   []byte
+}
+
+// putMultipart streams body to S3 in parts of opts.PartSize, uploading up
+// to opts.Concurrency parts at once. first holds the bytes already read
+// from body. The upload is aborted if any part fails after its retries.
+func (s *S3Store) putMultipart(ctx context.Context, key string, first []byte, body io.Reader, contentType string) error {
+	created, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("create multipart upload: %w", err)
+	}
+	uploadID := created.UploadId
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parts := make(chan uploadPart)
+	var (
+		mu        sync.Mutex
+		completed []types.CompletedPart
+		firstErr  error
+		wg        sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	for i := 0; i < s.multipart.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range parts {
+				etag, err := s.uploadPartWithRetry(ctx, key, uploadID, part)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				mu.Lock()
+				completed = append(completed, types.CompletedPart{
+					ETag:       etag,
+					PartNumber: aws.Int32(part.number),
+				})
+				mu.Unlock()
+			}
+		}()
+	}
+
+	readErr := s.readParts(ctx, first, body, parts)
+	close(parts)
+	wg.Wait()
+	if readErr != nil {
+		fail(readErr)
+	}
+
+	if firstErr != nil {
+		s.abortMultipart(key, uploadID)
+		return firstErr
+	}
+
+	sort.Slice(completed, func(i, j int) bool {
+		return aws.ToInt32(completed[i].PartNumber) < aws.ToInt32(completed[j].PartNumber)
+	})
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		s.abortMultipart(key, uploadID)
+		return fmt.Errorf("complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+// readParts splits body into parts and sends them to the upload workers.
+// The channel is unbuffered so at most Concurrency+1 parts are in memory.
+func (s *S3Store) readParts(ctx context.Context, first []byte, body io.Reader, parts chan<- uploadPart) error {
+	send := func(part uploadPart) bool {
+		select {
+		case parts <- part:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	if !send(uploadPart{number: 1, data: first}) {
+		return nil
+	}
+	for number := int32(2); ; number++ {
+		data := make([]byte, s.multipart.PartSize)
+		n, err := io.ReadFull(body, data)
+		if n > 0 && !send(uploadPart{number: number, data: data[:n]}) {
+			return nil
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read part %d: %w", number, err)
+		}
+	}
+}
+
+func (s *S3Store) uploadPartWithRetry(ctx context.Context, key string, uploadID *string, part uploadPart) (*string, error) {
+	var lastErr error
+	for attempt := 0; attempt <= s.multipart.MaxRetries; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(1<<(attempt-1)) * 500 * time.Millisecond
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		out, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(s.bucket),
+			Key:        aws.String(key),
+			UploadId:   uploadID,
+			PartNumber: aws.Int32(part.number),
+			Body:       bytes.NewReader(part.data),
+		})
+		if err == nil {
+			return out.ETag, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("upload part %d: %w", part.number, lastErr)
+}
+
+// abortMultipart uses its own context so cleanup still happens when the
+// request context has been cancelled
+func (s *S3Store) abortMultipart(key string, uploadID *string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+}
+
+func (s *S3Store) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	out, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.UploadId), nil
+}
+
+func (s *S3Store) PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, ttl time.Duration) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	req, err := s.presign.PresignUploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int32(partNumber),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (s *S3Store) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, types.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int32(part.PartNumber),
+		})
+	}
+	sort.Slice(completed, func(i, j int) bool {
+		return aws.ToInt32(completed[i].PartNumber) < aws.ToInt32(completed[j].PartNumber)
+	})
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	return mapS3Error(err)
+}
+
+func (s *S3Store) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return mapS3Error(err)
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound     = errors.New("storage: object not found")
+	ErrNotSupported = errors.New("storage: operation not supported by backend")
+	ErrInvalidKey   = errors.New("storage: invalid object key")
+)
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// BlobStore is the storage backend used for videos and thumbnails.
+// Keys are slash separated paths such as "landscape/abc.mp4".
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	Head(ctx context.Context, key string) (ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error)
+	PresignPut(ctx context.Context, key, contentType string, ttl time.Duration) (string, error)
+	// URL returns the public, unsigned URL of the object
+	URL(key string) string
+}
+
+// cleanKey normalises a key, dropping leading slashes and ./ elements, and
+// rejects keys that are empty or try to escape the store root. Every method
+// runs keys through it so that an object is found under the name it was
+// written with.
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean(strings.TrimLeft(key, "/"))
+	if key == "" || cleaned == "." || strings.HasPrefix(cleaned, "../") || cleaned == ".." {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}
+
+// cleanPrefix normalises a List prefix like cleanKey, keeping a trailing
+// slash so that "streams/abc/" doesn't also match "streams/abcd/". An empty
+// prefix, or one of slashes only, lists the whole store.
+func cleanPrefix(prefix string) (string, error) {
+	if strings.Trim(prefix, "/") == "" {
+		return "", nil
+	}
+	cleaned, err := cleanKey(prefix)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(prefix, "/") {
+		cleaned += "/"
+	}
+	return cleaned, nil
+}
+
+// streamingContentTypes covers extensions the system mime tables often get
+// wrong, .ts for instance is commonly mapped to TypeScript
+var streamingContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".mpd":  "application/dash+xml",
+	".m4s":  "video/iso.segment",
+	".vtt":  "text/vtt",
+}
+
+// ContentTypeForKey returns the media type served for key, based on its
+// extension
+func ContentTypeForKey(key string) string {
+	if contentType, ok := streamingContentTypes[path.Ext(key)]; ok {
+		return contentType
+	}
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+// joinURL appends key, normalised like a prefix, to baseURL. A key that
+// would escape the root is resolved against it instead.
+func joinURL(baseURL, key string) string {
+	cleaned, err := cleanPrefix(key)
+	if err != nil {
+		cleaned, _ = cleanPrefix(path.Clean("/" + key))
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + cleaned
+}
+
+// CompletedPart identifies an uploaded part of a multipart upload
+type CompletedPart struct {
+	PartNumber int32  `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+// MultipartPresigner is implemented by stores that let clients upload the
+// parts of a large object directly with presigned URLs
+type MultipartPresigner interface {
+	CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error)
+	PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, ttl time.Duration) (string, error)
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const testBaseURL = "http://localhost/assets"
+
+// testStores returns every backend, S3 talks to a fake bucket served by
+// newFakeS3
+func testStores(t *testing.T) map[string]BlobStore {
+	t.Helper()
+
+	local, err := NewLocalStore(t.TempDir(), testBaseURL)
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	srv := httptest.NewServer(newFakeS3())
+	t.Cleanup(srv.Close)
+	client := s3.New(s3.Options{
+		Region:                     "us-east-1",
+		BaseEndpoint:               aws.String(srv.URL),
+		UsePathStyle:               true,
+		Credentials:                credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	})
+	return map[string]BlobStore{
+		"memory": NewMemoryStore(testBaseURL),
+		"local":  local,
+		"s3":     NewS3Store(client, "bucket", testBaseURL, MultipartOptions{PartSize: MinPartSize}),
+	}
+}
+
+func TestStoreListPrefix(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, key := range []string{"streams/abc/a.ts", "/streams/abc/./b.m3u8", "streams/abcd/c.ts"} {
+				if err := s.Put(ctx, key, strings.NewReader("data"), "video/mp2t"); err != nil {
+					t.Fatalf("Put(%q): %v", key, err)
+				}
+			}
+
+			tests := []struct {
+				prefix string
+				want   []string
+			}{
+				{"", []string{"streams/abc/a.ts", "streams/abc/b.m3u8", "streams/abcd/c.ts"}},
+				{"/", []string{"streams/abc/a.ts", "streams/abc/b.m3u8", "streams/abcd/c.ts"}},
+				{"streams/abc/", []string{"streams/abc/a.ts", "streams/abc/b.m3u8"}},
+				{"/streams/abc/", []string{"streams/abc/a.ts", "streams/abc/b.m3u8"}},
+				{"./streams//abc/", []string{"streams/abc/a.ts", "streams/abc/b.m3u8"}},
+				{"streams/abc", []string{"streams/abc/a.ts", "streams/abc/b.m3u8", "streams/abcd/c.ts"}},
+				{"streams/abcd/../abc/a", []string{"streams/abc/a.ts"}},
+				{"other/", []string{}},
+			}
+			for _, tt := range tests {
+				objects, err := s.List(ctx, tt.prefix)
+				if err != nil {
+					t.Errorf("List(%q): %v", tt.prefix, err)
+					continue
+				}
+				keys := []string{}
+				for _, obj := range objects {
+					keys = append(keys, obj.Key)
+				}
+				slices.Sort(keys)
+				if !slices.Equal(keys, tt.want) {
+					t.Errorf("List(%q) = %v, want %v", tt.prefix, keys, tt.want)
+				}
+			}
+
+			if _, err := s.List(ctx, "../streams/"); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("List of an escaping prefix = %v, want ErrInvalidKey", err)
+			}
+		})
+	}
+}
+
+// Objects up to a part go up in one request, larger ones in parts
+func TestS3StorePutParts(t *testing.T) {
+	ctx := context.Background()
+	s := testStores(t)["s3"]
+	for _, size := range []int{0, 1000, MinPartSize - 1, MinPartSize, 2*MinPartSize + 1} {
+		data := bytes.Repeat([]byte{'x'}, size)
+		key := fmt.Sprintf("objects/%d", size)
+		if err := s.Put(ctx, key, bytes.NewReader(data), "application/octet-stream"); err != nil {
+			t.Fatalf("Put %d bytes: %v", size, err)
+		}
+		info, err := s.Head(ctx, key)
+		if err != nil {
+			t.Fatalf("Head %s: %v", key, err)
+		}
+		if info.Size != int64(size) {
+			t.Errorf("stored %d bytes, want %d", info.Size, size)
+		}
+	}
+}
+
+func TestStoreURL(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"", testBaseURL + "/"},
+		{"landscape/abc.mp4", testBaseURL + "/landscape/abc.mp4"},
+		{"/landscape//./abc.mp4", testBaseURL + "/landscape/abc.mp4"},
+		{"streams/abc/", testBaseURL + "/streams/abc/"},
+		{"../abc.mp4", testBaseURL + "/abc.mp4"},
+	}
+	for name, s := range testStores(t) {
+		for _, tt := range tests {
+			if got := s.URL(tt.key); got != tt.want {
+				t.Errorf("%s: URL(%q) = %q, want %q", name, tt.key, got, tt.want)
+			}
+		}
+	}
+}
+
+type fakeS3Object struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+// fakeS3 serves the object calls, multipart uploads and ListObjectsV2 of a
+// path-style bucket
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeS3Object
+	uploads map[string]map[int][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string]fakeS3Object{}, uploads: map[string]map[int][]byte{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet && query.Get("list-type") == "2":
+		f.list(w, query.Get("prefix"))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		uploadID := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[uploadID] = map[int][]byte{}
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, "<InitiateMultipartUploadResult><UploadId>"+uploadID+"</UploadId></InitiateMultipartUploadResult>")
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts := f.uploads[query.Get("uploadId")]
+		delete(f.uploads, query.Get("uploadId"))
+		var data []byte
+		for number := 1; number <= len(parts); number++ {
+			data = append(data, parts[number]...)
+		}
+		f.objects[key] = fakeS3Object{data: data, modified: time.Now()}
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if query.Has("uploadId") {
+			number, _ := strconv.Atoi(query.Get("partNumber"))
+			f.uploads[query.Get("uploadId")][number] = data
+			w.Header().Set("ETag", `"`+strconv.Itoa(number)+`"`)
+			return
+		}
+		f.objects[key] = fakeS3Object{data: data, contentType: r.Header.Get("Content-Type"), modified: time.Now()}
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		IsTruncated bool
+		Contents    []content
+	}{}
+	for key, obj := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{key, len(obj.data), obj.modified.UTC().Format(time.RFC3339)})
+		}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func TestContentTypeForKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"streams/abc/hls/master.m3u8", "application/vnd.apple.mpegurl"},
+		{"streams/abc/hls/720p/segment_000.ts", "video/mp2t"},
+		{"streams/abc/dash/manifest.mpd", "application/dash+xml"},
+		{"streams/abc/dash/chunk-0-00001.m4s", "video/iso.segment"},
+		{"landscape/abc/previews/previews.vtt", "text/vtt"},
+		{"landscape/abc/previews/sprite.jpg", "image/jpeg"},
+		{"landscape/abc", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		if got := ContentTypeForKey(tt.key); got != tt.want {
+			t.Errorf("ContentTypeForKey(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
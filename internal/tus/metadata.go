@@ -0,0 +1,32 @@
+package tus
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// ParseMetadata decodes an Upload-Metadata header, a comma separated list
+// of "key base64(value)" pairs where the value may be omitted
+func ParseMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, errors.New("tus: metadata value is not base64 encoded")
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, errors.New("tus: malformed metadata pair")
+		}
+	}
+	return metadata, nil
+}
@@ -0,0 +1,32 @@
+package tus
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseMetadata(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{}, false},
+		{"filename YS5tcDQ=", map[string]string{"filename": "a.mp4"}, false},
+		{"filename YS5tcDQ=, is_private", map[string]string{"filename": "a.mp4", "is_private": ""}, false},
+		{"  title  dGl0bGU=  ", map[string]string{"title": "title"}, false},
+		{"filename a.mp4", nil, true},
+		{"filename YS5tcDQ= extra", nil, true},
+		{"filename YS5tcDQ=,,title dGl0bGU=", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMetadata(tt.header)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseMetadata(%q) error = %v, want error %v", tt.header, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !maps.Equal(got, tt.want) {
+			t.Errorf("ParseMetadata(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
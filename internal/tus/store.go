@@ -0,0 +1,221 @@
+package tus
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound       = errors.New("tus: upload not found")
+	ErrOffsetMismatch = errors.New("tus: offset does not match upload offset")
+	ErrLocked         = errors.New("tus: upload is locked by another request")
+)
+
+// Upload is the persisted state of a resumable upload. The offset is not
+// stored, it is the size of the data file so it survives restarts and
+// interrupted writes.
+type Upload struct {
+	ID        string            `json:"id"`
+	VideoID   uuid.UUID         `json:"video_id"`
+	UserID    uuid.UUID         `json:"user_id"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"-"`
+	Metadata  map[string]string `json:"metadata"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// Store keeps uploads on disk as an <id>.info JSON file next to an <id>.bin
+// data file
+type Store struct {
+	dir string
+
+	mu     sync.Mutex
+	locked map[string]bool
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, locked: map[string]bool{}}, nil
+}
+
+func (s *Store) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".info")
+}
+
+// DataPath returns the path of the file holding the uploaded bytes
+func (s *Store) DataPath(id string) string {
+	return filepath.Join(s.dir, id+".bin")
+}
+
+func (s *Store) Create(upload Upload) (Upload, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Upload{}, err
+	}
+	upload.ID = hex.EncodeToString(id)
+	upload.Offset = 0
+	upload.CreatedAt = time.Now().UTC()
+
+	data, err := os.Create(s.DataPath(upload.ID))
+	if err != nil {
+		return Upload{}, err
+	}
+	data.Close()
+
+	info, err := json.Marshal(upload)
+	if err != nil {
+		return Upload{}, err
+	}
+	if err := os.WriteFile(s.infoPath(upload.ID), info, 0644); err != nil {
+		os.Remove(s.DataPath(upload.ID))
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+func (s *Store) Get(id string) (Upload, error) {
+	if !validID(id) {
+		return Upload{}, ErrNotFound
+	}
+	info, err := os.ReadFile(s.infoPath(id))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Upload{}, ErrNotFound
+		}
+		return Upload{}, err
+	}
+	var upload Upload
+	if err := json.Unmarshal(info, &upload); err != nil {
+		return Upload{}, err
+	}
+
+	stat, err := os.Stat(s.DataPath(id))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Upload{}, ErrNotFound
+		}
+		return Upload{}, err
+	}
+	upload.Offset = stat.Size()
+	return upload, nil
+}
+
+// Append writes body at offset, which must match the current offset of the
+// upload. Bytes past the declared length are ignored. The new offset is
+// returned even when the body ends early so clients can resume from it.
+func (s *Store) Append(id string, offset int64, body io.Reader) (int64, error) {
+	if err := s.lock(id); err != nil {
+		return 0, err
+	}
+	defer s.unlock(id)
+
+	upload, err := s.Get(id)
+	if err != nil {
+		return 0, err
+	}
+	if upload.Offset != offset {
+		return upload.Offset, ErrOffsetMismatch
+	}
+
+	f, err := os.OpenFile(s.DataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return upload.Offset, err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, io.LimitReader(body, upload.Length-upload.Offset))
+	return upload.Offset + n, err
+}
+
+// List returns every upload in the store
+func (s *Store) List() ([]Upload, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.info"))
+	if err != nil {
+		return nil, err
+	}
+	uploads := []Upload{}
+	for _, match := range matches {
+		upload, err := s.Get(strings.TrimSuffix(filepath.Base(match), ".info"))
+		if errors.Is(err, ErrNotFound) {
+			// removed since the glob
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
+
+// Delete removes the upload and its data
+func (s *Store) Delete(id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	if err := s.lock(id); err != nil {
+		return err
+	}
+	defer s.unlock(id)
+	return s.Remove(id)
+}
+
+// Remove deletes the upload without taking the lock, the caller must
+// already hold it through Lock
+func (s *Store) Remove(id string) error {
+	err := os.Remove(s.infoPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(s.DataPath(id))
+}
+
+// Lock marks the upload as busy, it is used while a completed upload is
+// being processed so it can't be modified or deleted underneath
+func (s *Store) Lock(id string) error {
+	return s.lock(id)
+}
+
+func (s *Store) Unlock(id string) {
+	s.unlock(id)
+}
+
+func (s *Store) lock(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked[id] {
+		return ErrLocked
+	}
+	s.locked[id] = true
+	return nil
+}
+
+func (s *Store) unlock(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.locked, id)
+}
+
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,135 @@
+package tus
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func newTestStore(t *testing.T, dir string) *Store {
+	t.Helper()
+
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	return s
+}
+
+func TestStoreAppend(t *testing.T) {
+	tests := []struct {
+		name       string
+		offset     int64
+		body       string
+		wantOffset int64
+		wantErr    error
+	}{
+		{"first chunk", 0, "abc", 3, nil},
+		{"stale offset", 0, "abc", 3, ErrOffsetMismatch},
+		{"offset ahead", 5, "abc", 3, ErrOffsetMismatch},
+		{"next chunk", 3, "def", 6, nil},
+		{"past the length", 6, "ghijkl", 8, nil},
+		{"complete", 8, "more", 8, nil},
+	}
+
+	s := newTestStore(t, t.TempDir())
+	upload, err := s.Create(Upload{VideoID: uuid.New(), UserID: uuid.New(), Length: 8})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for _, tt := range tests {
+		offset, err := s.Append(upload.ID, tt.offset, strings.NewReader(tt.body))
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: Append error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if offset != tt.wantOffset {
+			t.Errorf("%s: Append offset = %d, want %d", tt.name, offset, tt.wantOffset)
+		}
+	}
+
+	data, err := os.ReadFile(s.DataPath(upload.ID))
+	if err != nil {
+		t.Fatalf("read data: %v", err)
+	}
+	if string(data) != "abcdefgh" {
+		t.Errorf("data = %q, want the body cut at the declared length", data)
+	}
+}
+
+// The offset is the size of the data file, a store opened on the same
+// directory after a restart resumes from it
+func TestStoreOffsetSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir)
+	upload, err := s.Create(Upload{VideoID: uuid.New(), UserID: uuid.New(), Length: 10, Metadata: map[string]string{"filename": "a.mp4"}})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := s.Append(upload.ID, 0, strings.NewReader("hello")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	restarted := newTestStore(t, dir)
+	got, err := restarted.Get(upload.ID)
+	if err != nil {
+		t.Fatalf("Get after restart: %v", err)
+	}
+	if got.Offset != 5 || got.Length != 10 || got.VideoID != upload.VideoID || got.Metadata["filename"] != "a.mp4" {
+		t.Errorf("Get after restart = %+v, want offset 5 of upload %+v", got, upload)
+	}
+	if offset, err := restarted.Append(upload.ID, 5, strings.NewReader("world")); err != nil || offset != 10 {
+		t.Errorf("Append after restart = %d, %v, want 10", offset, err)
+	}
+
+	uploads, err := restarted.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(uploads) != 1 || uploads[0].ID != upload.ID || uploads[0].Offset != 10 {
+		t.Errorf("List = %+v, want only %s at offset 10", uploads, upload.ID)
+	}
+}
+
+func TestStoreLock(t *testing.T) {
+	s := newTestStore(t, t.TempDir())
+	upload, err := s.Create(Upload{VideoID: uuid.New(), UserID: uuid.New(), Length: 3})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if err := s.Lock(upload.ID); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if err := s.Lock(upload.ID); !errors.Is(err, ErrLocked) {
+		t.Errorf("second Lock = %v, want ErrLocked", err)
+	}
+	if _, err := s.Append(upload.ID, 0, strings.NewReader("abc")); !errors.Is(err, ErrLocked) {
+		t.Errorf("Append while locked = %v, want ErrLocked", err)
+	}
+	if err := s.Delete(upload.ID); !errors.Is(err, ErrLocked) {
+		t.Errorf("Delete while locked = %v, want ErrLocked", err)
+	}
+
+	s.Unlock(upload.ID)
+	if _, err := s.Append(upload.ID, 0, strings.NewReader("abc")); err != nil {
+		t.Errorf("Append after Unlock: %v", err)
+	}
+	if err := s.Delete(upload.ID); err != nil {
+		t.Errorf("Delete after Unlock: %v", err)
+	}
+	if _, err := s.Get(upload.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStoreGetInvalidID(t *testing.T) {
+	s := newTestStore(t, t.TempDir())
+	for _, id := range []string{"", "../secret", strings.Repeat("z", 32), strings.Repeat("a", 32)} {
+		if _, err := s.Get(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) = %v, want ErrNotFound", id, err)
+		}
+	}
+}
@@ -5,18 +5,20 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tus"
 	"github.com/google/uuid"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	db               database.Client
+	db               database.Store
 	jwtSecret        string
 	platform         string
 	filepathRoot     string
@@ -24,8 +26,35 @@ type apiConfig struct {
 	s3Bucket         string
 	s3Region         string
 	s3CfDistribution string
+	s3Endpoint       string
+	s3UsePathStyle   bool
+	s3AccessKeyID    string
+	s3SecretKey      string
+	s3SkipTLSVerify  bool
 	port             string
-	s3Client         *s3.Client
+	storageBackend   string
+	s3Multipart      storage.MultipartOptions
+	videoStorage     storage.BlobStore
+	assetStorage     storage.BlobStore
+	tusStore         *tus.Store
+	presignUploadTTL time.Duration
+	presignGetTTL    time.Duration
+	signedURLMaxTTL  time.Duration
+	cfSigner         *cdn.Signer
+	streamRenditions []streamRendition
+	streamFormats    map[string]bool
+	autoThumbnail    autoThumbnailOptions
+	thumbnails       thumbnailOptions
+	previews         previewOptions
+	videoFormats     map[string]videoInputFormat
+	archiveOriginals bool
+
+	assetDeleteMaxAttempts int
+	gcOptions              gcOptions
+
+	jobs           *jobs.Pool
+	jobSpoolDir    string
+	jobMaxAttempts int
 }
 
 type thumbnail struct {
@@ -38,12 +67,17 @@ var videoThumbnails = map[uuid.UUID]thumbnail{}
 func main() {
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
-		log.Fatal("DB_URL must be set")
+	// DB_URL takes a postgres:// URL or memory:// for a throwaway in-memory
+	// store, DB_PATH a SQLite file for local dev
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		dbURL = os.Getenv("DB_PATH")
+	}
+	if dbURL == "" {
+		log.Fatal("DB_URL or DB_PATH must be set")
 	}
 
-	db, err := database.NewClient(pathToDB)
+	db, err := database.Open(dbURL, getEnvBool("DB_AUTO_MIGRATE", true))
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
@@ -68,31 +102,132 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	port := os.Getenv("PORT")
+	if port == "" {
+		log.Fatal("PORT environment variable is not set")
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = StorageBackendS3
 	}
 
+	s3Bucket := os.Getenv("S3_BUCKET")
+	s3Region := os.Getenv("S3_REGION")
 	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	s3Endpoint := os.Getenv("S3_ENDPOINT")
+	if storageBackend == StorageBackendS3 {
+		if s3Bucket == "" {
+			log.Fatal("S3_BUCKET environment variable is not set")
+		}
+		if s3Region == "" {
+			log.Fatal("S3_REGION environment variable is not set")
+		}
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	s3AccessKeyID := os.Getenv("S3_ACCESS_KEY_ID")
+	s3SecretKey := os.Getenv("S3_SECRET_ACCESS_KEY")
+	if (s3AccessKeyID == "") != (s3SecretKey == "") {
+		log.Fatal("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set together")
+	}
+
+	s3SkipTLSVerify := getEnvBool("S3_INSECURE_SKIP_VERIFY", false)
+	if s3SkipTLSVerify && platform != "dev" {
+		log.Fatal("S3_INSECURE_SKIP_VERIFY is only allowed in dev environment")
+	}
+
+	tusUploadDir := os.Getenv("TUS_UPLOAD_DIR")
+	if tusUploadDir == "" {
+		tusUploadDir = "./tus_uploads"
+	}
+	tusStore, err := tus.NewStore(tusUploadDir)
+	if err != nil {
+		log.Fatalf("Couldn't create tus upload directory: %v", err)
+	}
+
+	jobSpoolDir := os.Getenv("JOB_SPOOL_DIR")
+	if jobSpoolDir == "" {
+		jobSpoolDir = "./job_spool"
+	}
+	if err := os.MkdirAll(jobSpoolDir, 0o755); err != nil {
+		log.Fatalf("Couldn't create job spool directory: %v", err)
+	}
+
+	s3Multipart := storage.MultipartOptions{
+		PartSize:    int64(getEnvInt("S3_UPLOAD_PART_SIZE_MB", 16)) << 20,
+		Concurrency: getEnvInt("S3_UPLOAD_CONCURRENCY", 4),
+		MaxRetries:  getEnvInt("S3_UPLOAD_MAX_RETRIES", 3),
+	}
+	if s3Multipart.PartSize < storage.MinPartSize {
+		log.Fatal("S3_UPLOAD_PART_SIZE_MB must be at least 5")
+	}
+
+	gcMode := os.Getenv("GC_MODE")
+	if gcMode == "" {
+		gcMode = GCModeQuarantine
+	}
+	if gcMode != GCModeDelete && gcMode != GCModeQuarantine {
+		log.Fatal("GC_MODE must be delete or quarantine")
 	}
 
-	awsCfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
+	streamRenditions, err := parseRenditions(os.Getenv("STREAM_RENDITIONS"))
 	if err != nil {
-		log.Fatalf("Couldn't create aws config: %v", err)
+		log.Fatalf("Invalid STREAM_RENDITIONS: %v", err)
+	}
+
+	streamFormatsSpec, ok := os.LookupEnv("STREAM_FORMATS")
+	if !ok {
+		streamFormatsSpec = StreamFormatHLS
+	}
+	streamFormats, err := parseStreamFormats(streamFormatsSpec)
+	if err != nil {
+		log.Fatalf("Invalid STREAM_FORMATS: %v", err)
+	}
+
+	videoFormatsSpec, ok := os.LookupEnv("VIDEO_INPUT_FORMATS")
+	if !ok {
+		videoFormatsSpec = "mp4,mov,webm,mkv,avi"
+	}
+	videoFormats, err := parseVideoInputFormats(videoFormatsSpec)
+	if err != nil {
+		log.Fatalf("Invalid VIDEO_INPUT_FORMATS: %v", err)
+	}
+
+	autoThumbnailMode := os.Getenv("AUTO_THUMBNAIL_MODE")
+	if autoThumbnailMode == "" {
+		autoThumbnailMode = AutoThumbnailBest
+	}
+	switch autoThumbnailMode {
+	case AutoThumbnailOff, AutoThumbnailTimestamp, AutoThumbnailBest:
+	default:
+		log.Fatal("AUTO_THUMBNAIL_MODE must be off, timestamp or best")
+	}
+
+	thumbnailWidthsSpec := os.Getenv("THUMBNAIL_WIDTHS")
+	if thumbnailWidthsSpec == "" {
+		thumbnailWidthsSpec = "320,640,1280"
+	}
+	thumbnailWidths, err := parseThumbnailWidths(thumbnailWidthsSpec)
+	if err != nil {
+		log.Fatalf("Invalid THUMBNAIL_WIDTHS: %v", err)
+	}
+
+	thumbnailFormatsSpec, ok := os.LookupEnv("THUMBNAIL_FORMATS")
+	if !ok {
+		thumbnailFormatsSpec = "webp"
+	}
+	thumbnailFormats, err := parseThumbnailFormats(thumbnailFormatsSpec)
+	if err != nil {
+		log.Fatalf("Invalid THUMBNAIL_FORMATS: %v", err)
+	}
+
+	var cfSigner *cdn.Signer
+	if cfKeyPairID := os.Getenv("CF_KEY_PAIR_ID"); cfKeyPairID != "" {
+		cfSigner, err = cdn.NewSigner(cfKeyPairID, os.Getenv("CF_PRIVATE_KEY_PATH"), os.Getenv("CF_COOKIE_DOMAIN"))
+		if err != nil {
+			log.Fatalf("Couldn't load CloudFront signing key: %v", err)
+		}
 	}
-	s3Client := s3.NewFromConfig(awsCfg)
 
 	cfg := apiConfig{
 		db:               db,
@@ -103,20 +238,78 @@ func main() {
 		s3Bucket:         s3Bucket,
 		s3Region:         s3Region,
 		s3CfDistribution: s3CfDistribution,
+		s3Endpoint:       s3Endpoint,
+		s3UsePathStyle:   getEnvBool("S3_USE_PATH_STYLE", false),
+		s3AccessKeyID:    s3AccessKeyID,
+		s3SecretKey:      s3SecretKey,
+		s3SkipTLSVerify:  s3SkipTLSVerify,
 		port:             port,
-		s3Client:         s3Client,
+		storageBackend:   storageBackend,
+		s3Multipart:      s3Multipart,
+		tusStore:         tusStore,
+		presignUploadTTL: getEnvDuration("PRESIGN_UPLOAD_TTL", 15*time.Minute),
+		presignGetTTL:    getEnvDuration("PRESIGN_GET_TTL", time.Hour),
+		signedURLMaxTTL:  getEnvDuration("SIGNED_URL_MAX_TTL", 24*time.Hour),
+		cfSigner:         cfSigner,
+		streamRenditions: streamRenditions,
+		streamFormats:    streamFormats,
+		previews: previewOptions{
+			Interval: getEnvDuration("PREVIEW_INTERVAL", 5*time.Second),
+			Width:    getEnvInt("PREVIEW_WIDTH", 160),
+		},
+		videoFormats:     videoFormats,
+		archiveOriginals: getEnvBool("ARCHIVE_ORIGINALS", false),
+		autoThumbnail: autoThumbnailOptions{
+			Mode: autoThumbnailMode,
+			At:   getEnvDuration("AUTO_THUMBNAIL_AT", 3*time.Second),
+		},
+		thumbnails: thumbnailOptions{
+			Widths:  thumbnailWidths,
+			Formats: thumbnailFormats,
+		},
+
+		assetDeleteMaxAttempts: getEnvInt("ASSET_DELETE_MAX_ATTEMPTS", 10),
+		gcOptions: gcOptions{
+			GracePeriod: getEnvDuration("GC_GRACE_PERIOD", 24*time.Hour),
+			Mode:        gcMode,
+		},
+
+		jobs: jobs.NewPool(db, jobs.Options{
+			Workers:      getEnvInt("JOB_WORKERS", 2),
+			PollInterval: getEnvDuration("JOB_POLL_INTERVAL", 2*time.Second),
+			RetryBackoff: getEnvDuration("JOB_RETRY_BACKOFF", 30*time.Second),
+		}),
+		jobSpoolDir:    jobSpoolDir,
+		jobMaxAttempts: getEnvInt("JOB_MAX_ATTEMPTS", 3),
 	}
+	cfg.jobs.Register(JobTypeProcessVideo, cfg.processVideoJob)
 
-	err = cfg.ensureAssetsDir()
+	err = cfg.setupStorage(context.Background())
 	if err != nil {
-		log.Fatalf("Couldn't create assets directory: %v", err)
+		log.Fatalf("Couldn't set up storage: %v", err)
+	}
+
+	if len(os.Args) > 1 {
+		if err := cfg.runCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := cfg.jobs.Start(context.Background()); err != nil {
+		log.Fatalf("Couldn't start job workers: %v", err)
+	}
+	go cfg.runAssetDeletionRetries(context.Background(), getEnvDuration("ASSET_DELETE_RETRY_INTERVAL", 5*time.Minute))
+	go cfg.runAbandonedUploadSweeps(context.Background(), getEnvDuration("UPLOAD_SWEEP_INTERVAL", 5*time.Minute))
+	if gcInterval := getEnvDuration("GC_INTERVAL", 24*time.Hour); gcInterval > 0 {
+		go cfg.runGarbageCollector(context.Background(), gcInterval, cfg.gcOptions)
 	}
 
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
+	assetsHandler := http.StripPrefix("/assets/", blobHandler(cfg.assetStorage))
 	mux.Handle("/assets/", NoCacheMiddleware(assetsHandler))
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
@@ -128,8 +321,18 @@ func main() {
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
 	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
 	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/presign", cfg.handlerVideoUploadPresign)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/complete", cfg.handlerVideoUploadComplete)
+	mux.HandleFunc("OPTIONS /api/video_upload/{videoID}/tus", cfg.handlerTusOptions)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/tus", cfg.handlerTusCreate)
+	mux.HandleFunc("HEAD /api/video_upload/{videoID}/tus/{uploadID}", cfg.handlerTusHead)
+	mux.HandleFunc("PATCH /api/video_upload/{videoID}/tus/{uploadID}", cfg.handlerTusPatch)
+	mux.HandleFunc("DELETE /api/video_upload/{videoID}/tus/{uploadID}", cfg.handlerTusDelete)
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/cookies", cfg.handlerVideoCookies)
+	mux.HandleFunc("GET /api/videos/{videoID}/previews.vtt", cfg.handlerVideoPreviewTrack)
+	mux.HandleFunc("GET /api/jobs/{jobID}", cfg.handlerJobGet)
 	mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
 
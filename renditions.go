@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// streamRendition is one rung of the adaptive streaming bitrate ladder
+type streamRendition struct {
+	Name         string
+	Height       int
+	VideoBitrate int // kbit/s
+	AudioBitrate int // kbit/s
+}
+
+// encodedRendition is a rendition transcoded to its own MP4 file, the input
+// for the HLS and DASH packagers
+type encodedRendition struct {
+	streamRendition
+	Path     string
+	HasAudio bool
+}
+
+// defaultRenditionBitrates are used for renditions given only by height
+var defaultRenditionBitrates = map[int][2]int{
+	2160: {14000, 192},
+	1440: {9000, 192},
+	1080: {5000, 192},
+	720:  {2800, 128},
+	480:  {1400, 128},
+	360:  {800, 96},
+	240:  {400, 64},
+}
+
+// parseRenditions parses a comma separated ladder such as
+// "1080p,720p,480p". A rendition can set its video bitrate in kbit/s with
+// an @ suffix, e.g. "720p@2500".
+func parseRenditions(spec string) ([]streamRendition, error) {
+	renditions := []streamRendition{}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		name, bitrate, hasBitrate := strings.Cut(field, "@")
+		height, err := strconv.Atoi(strings.TrimSuffix(name, "p"))
+		if err != nil || height <= 0 || height%2 != 0 {
+			return nil, fmt.Errorf("invalid rendition %q", field)
+		}
+
+		defaults, ok := defaultRenditionBitrates[height]
+		if !ok && !hasBitrate {
+			return nil, fmt.Errorf("rendition %q needs a bitrate, e.g. %s@1000", field, name)
+		}
+		r := streamRendition{
+			Name:         fmt.Sprintf("%dp", height),
+			Height:       height,
+			VideoBitrate: defaults[0],
+			AudioBitrate: defaults[1],
+		}
+		if r.AudioBitrate == 0 {
+			r.AudioBitrate = 128
+		}
+		if hasBitrate {
+			r.VideoBitrate, err = strconv.Atoi(bitrate)
+			if err != nil || r.VideoBitrate <= 0 {
+				return nil, fmt.Errorf("invalid bitrate in rendition %q", field)
+			}
+		}
+		renditions = append(renditions, r)
+	}
+	return renditions, nil
+}
+
+// renditionsForHeight drops renditions taller than the source so the ladder
+// never upscales, the smallest rendition is always kept
+func renditionsForHeight(renditions []streamRendition, sourceHeight int) []streamRendition {
+	kept := []streamRendition{}
+	smallest := renditions[0]
+	for _, r := range renditions {
+		if r.Height <= sourceHeight {
+			kept = append(kept, r)
+		}
+		if r.Height < smallest.Height {
+			smallest = r
+		}
+	}
+	if len(kept) == 0 {
+		kept = append(kept, smallest)
+	}
+	return kept
+}
+
+// encodeRenditions transcodes srcPath, described by probe, once per
+// rendition into workDir. Key frames are forced on segment boundaries so
+// every packager can cut the files without re-encoding.
+func encodeRenditions(srcPath string, probe videoProbe, workDir string, renditions []streamRendition) ([]encodedRendition, error) {
+	renditions = renditionsForHeight(renditions, probe.Height)
+
+	var filter strings.Builder
+	fmt.Fprintf(&filter, "[0:v]split=%d", len(renditions))
+	for i := range renditions {
+		fmt.Fprintf(&filter, "[v%d]", i)
+	}
+	for i, r := range renditions {
+		fmt.Fprintf(&filter, ";[v%d]scale=-2:%d[v%dout]", i, r.Height, i)
+	}
+
+	args := []string{"-i", srcPath, "-filter_complex", filter.String()}
+	encoded := []encodedRendition{}
+	for i, r := range renditions {
+		out := encodedRendition{
+			streamRendition: r,
+			Path:            filepath.Join(workDir, r.Name+MP4Extension),
+			HasAudio:        probe.HasAudio,
+		}
+		args = append(args,
+			"-map", fmt.Sprintf("[v%dout]", i),
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+			"-b:v", fmt.Sprintf("%dk", r.VideoBitrate),
+			"-maxrate", fmt.Sprintf("%dk", r.VideoBitrate*107/100),
+			"-bufsize", fmt.Sprintf("%dk", r.VideoBitrate*3/2),
+			"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", StreamSegmentSeconds),
+		)
+		if probe.HasAudio {
+			args = append(args,
+				"-map", "0:a:0",
+				"-c:a", "aac",
+				"-b:a", fmt.Sprintf("%dk", r.AudioBitrate),
+			)
+		}
+		args = append(args, out.Path)
+		encoded = append(encoded, out)
+	}
+
+	if err := runFFmpeg(args...); err != nil {
+		return nil, err
+	}
+	return encoded, nil
+}
+
+// runFFmpeg runs ffmpeg, including its last line of output in the error
+func runFFmpeg(args ...string) error {
+	cmd := exec.Command("ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg: %v: %s", err, lastLine(stderr.String()))
+	}
+	return nil
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// sniffLen is how much of a file is read to detect its type
+const sniffLen = 512
+
+// sniffMediaType detects the media type of a file from its first bytes,
+// it returns "" for anything it doesn't recognise
+func sniffMediaType(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return ImageJPEGType
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return ImagePNGType
+	case len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return ImageWebPType
+	case len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "AVI ":
+		return "video/x-msvideo"
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		// Matroska and WebM share the EBML header, the DocType tells them apart
+		if bytes.Contains(head, []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		// the ftyp box is shared by HEIF and AVIF images, 3GP and audio
+		// files, only the major brands of MP4 and MOV video are accepted
+		switch brand := string(head[8:12]); {
+		case brand == "qt  ":
+			return "video/quicktime"
+		case isMP4VideoBrand(brand):
+			return VideoMP4Type
+		}
+	case len(head) >= 8 && isQuickTimeAtom(string(head[4:8])):
+		// older QuickTime files start without an ftyp box
+		return "video/quicktime"
+	}
+	return ""
+}
+
+func isMP4VideoBrand(brand string) bool {
+	switch brand {
+	case "isom", "iso2", "iso3", "iso4", "iso5", "iso6", "iso7", "iso8", "iso9",
+		"mp41", "mp42", "avc1", "dash", "mmp4", "msnv", "M4V ", "M4VH", "M4VP", "f4v ":
+		return true
+	}
+	return false
+}
+
+func isQuickTimeAtom(atom string) bool {
+	switch atom {
+	case "moov", "mdat", "wide", "free", "skip", "pnot":
+		return true
+	}
+	return false
+}
+
+// readHead reads up to sniffLen bytes from the start of a file
+func readHead(filePath string) ([]byte, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// checkSniffedVideoType detects the container of a video upload and checks
+// it is allowed and agrees with the claimed media type. Containers that
+// share a demuxer, such as MP4 and MOV, are treated as agreeing. The
+// detected media type is returned.
+func (cfg *apiConfig) checkSniffedVideoType(claimed string, head []byte) (string, error) {
+	detected := sniffMediaType(head)
+	if detected == "" {
+		return "", NewValidationError("video", "file is not a recognised video format")
+	}
+
+	detectedFormat, ok := cfg.videoInputFormat(detected)
+	if !ok {
+		return "", cfg.unsupportedVideoTypeError()
+	}
+
+	if claimedFormat, ok := knownVideoInputFormat(claimed); claimed != "" && (!ok || claimedFormat.Demuxer != detectedFormat.Demuxer) {
+		return "", NewValidationError("video", fmt.Sprintf("file content is %s but was uploaded as %s", detected, claimed))
+	}
+	return detected, nil
+}
+
+// confirmProbedFormat checks that ffprobe opened the file with the demuxer
+// expected for its sniffed media type
+func confirmProbedFormat(mediaType string, probe videoProbe) error {
+	format, ok := knownVideoInputFormat(mediaType)
+	if !ok {
+		return NewValidationError("video", "unsupported video format "+mediaType)
+	}
+	for _, name := range strings.Split(probe.Format, ",") {
+		if name == format.Demuxer {
+			return nil
+		}
+	}
+	return NewValidationError("video", fmt.Sprintf("file content is %s but was detected as %s", probe.Format, mediaType))
+}
+
+// checkSniffedImageType detects the type of an image upload and checks it
+// is one of allowed and matches the claimed media type
+func checkSniffedImageType(claimed string, head []byte, allowed ...string) (string, error) {
+	detected := sniffMediaType(head)
+	for _, mediaType := range allowed {
+		if detected != mediaType {
+			continue
+		}
+		if claimed != "" && claimed != detected {
+			return "", NewValidationError("thumbnail", fmt.Sprintf("file content is %s but was uploaded as %s", detected, claimed))
+		}
+		return detected, nil
+	}
+	return "", NewValidationError("thumbnail", "file is not a JPEG, PNG or WebP image")
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// streamOutputs are the keys of the adaptive streaming manifests of a video,
+// empty for formats that weren't produced
+type streamOutputs struct {
+	HLSKey  string
+	DASHKey string
+}
+
+// parseStreamFormats parses a comma separated list of streaming formats
+func parseStreamFormats(spec string) (map[string]bool, error) {
+	formats := map[string]bool{}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		switch field {
+		case "":
+		case StreamFormatHLS, StreamFormatDASH:
+			formats[field] = true
+		default:
+			return nil, fmt.Errorf("unknown streaming format %q", field)
+		}
+	}
+	return formats, nil
+}
+
+// streamKeyPrefix is the per-video prefix holding every streaming output
+func streamKeyPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("%s/%s/", StreamsPrefix, videoID)
+}
+
+// storeStreams encodes the rendition ladder of srcPath, described by probe,
+// once and packages it into each configured streaming format, uploading the
+// output under the video's stream prefix. Objects left there by an earlier
+// run that the new output doesn't overwrite are removed afterwards.
+func (cfg *apiConfig) storeStreams(ctx context.Context, videoID uuid.UUID, srcPath string, probe videoProbe) (streamOutputs, error) {
+	outputs := streamOutputs{}
+	prefix := streamKeyPrefix(videoID)
+	uploaded := map[string]bool{}
+	if len(cfg.streamRenditions) == 0 || len(cfg.streamFormats) == 0 {
+		cfg.deleteStaleStreams(ctx, prefix, uploaded)
+		return outputs, nil
+	}
+
+	workDir, err := os.MkdirTemp("", "tubely-streams-")
+	if err != nil {
+		return outputs, NewFileProcessingError("temp_dir", "couldn't create streaming directory")
+	}
+	defer os.RemoveAll(workDir)
+
+	encoded, err := encodeRenditions(srcPath, probe, workDir, cfg.streamRenditions)
+	if err != nil {
+		return outputs, NewFileProcessingError("renditions", err.Error())
+	}
+
+	if cfg.streamFormats[StreamFormatHLS] {
+		outputs.HLSKey, err = cfg.storeStreamFormat(ctx, workDir, prefix, HLSDir, encoded, packageHLS, uploaded)
+		if err != nil {
+			return outputs, err
+		}
+	}
+	if cfg.streamFormats[StreamFormatDASH] {
+		outputs.DASHKey, err = cfg.storeStreamFormat(ctx, workDir, prefix, DASHDir, encoded, packageDASH, uploaded)
+		if err != nil {
+			return outputs, err
+		}
+	}
+	cfg.deleteStaleStreams(ctx, prefix, uploaded)
+	return outputs, nil
+}
+
+// deleteStaleStreams removes the objects under prefix that aren't in keep,
+// such as segments of a rendition or format that is no longer configured.
+// Leftovers are only logged, they are still removed with the video.
+func (cfg *apiConfig) deleteStaleStreams(ctx context.Context, prefix string, keep map[string]bool) {
+	objects, err := cfg.videoStorage.List(ctx, prefix)
+	if err != nil {
+		log.Printf("Couldn't list stream output under %s: %v", prefix, err)
+		return
+	}
+	for _, obj := range objects {
+		if keep[obj.Key] {
+			continue
+		}
+		if err := cfg.videoStorage.Delete(ctx, obj.Key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Couldn't delete stale stream object %s: %v", obj.Key, err)
+		}
+	}
+}
+
+// storeStreamFormat runs one packager into workDir/dir and uploads the
+// result to prefix+dir, adding each key to uploaded. It returns the key of
+// the manifest.
+func (cfg *apiConfig) storeStreamFormat(
+	ctx context.Context,
+	workDir, prefix, dir string,
+	encoded []encodedRendition,
+	packager func([]encodedRendition, string) (string, error),
+	uploaded map[string]bool,
+) (string, error) {
+	outDir := filepath.Join(workDir, dir)
+	if err := os.Mkdir(outDir, 0o755); err != nil {
+		return "", NewFileProcessingError("temp_dir", "couldn't create "+dir+" directory")
+	}
+
+	manifest, err := packager(encoded, outDir)
+	if err != nil {
+		return "", NewFileProcessingError(dir, err.Error())
+	}
+
+	keyPrefix := prefix + dir + "/"
+	if err := cfg.uploadDir(ctx, outDir, keyPrefix, uploaded); err != nil {
+		return "", NewStorageError("upload", fmt.Sprintf("failed to upload %s output: %v", dir, err))
+	}
+	return keyPrefix + filepath.ToSlash(manifest), nil
+}
+
+// uploadDir stores every file below dir in video storage under prefix and
+// adds its key to uploaded
+func (cfg *apiConfig) uploadDir(ctx context.Context, dir, prefix string, uploaded map[string]bool) error {
+	return filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		key := prefix + filepath.ToSlash(rel)
+		if err := cfg.videoStorage.Put(ctx, key, f, storage.ContentTypeForKey(key)); err != nil {
+			return err
+		}
+		uploaded[key] = true
+		return nil
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDeleteStaleStreams(t *testing.T) {
+	ctx := context.Background()
+	cfg := newTestConfig()
+	videoID, otherID := uuid.New(), uuid.New()
+	prefix := streamKeyPrefix(videoID)
+	keys := []string{
+		prefix + "hls/master.m3u8",
+		prefix + "hls/1080p/segment_000.ts",
+		prefix + "dash/manifest.mpd",
+		streamKeyPrefix(otherID) + "hls/master.m3u8",
+	}
+	for _, key := range keys {
+		if err := cfg.videoStorage.Put(ctx, key, strings.NewReader("data"), ""); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	cfg.deleteStaleStreams(ctx, prefix, map[string]bool{prefix + "hls/master.m3u8": true})
+
+	objects, err := cfg.videoStorage.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	got := []string{}
+	for _, obj := range objects {
+		got = append(got, obj.Key)
+	}
+	want := []string{keys[0], keys[3]}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("left %v, want %v", got, want)
+	}
+}
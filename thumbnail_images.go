@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// thumbnailOptions configures how uploaded thumbnails are normalised
+type thumbnailOptions struct {
+	Widths []int
+	// Formats are the media types encoded alongside JPEG or PNG, by ffmpeg
+	Formats []string
+}
+
+// thumbnailImage is one encoded variant of a thumbnail
+type thumbnailImage struct {
+	Width     int
+	MediaType string
+	Path      string
+}
+
+// parseThumbnailWidths parses a comma separated list of variant widths
+func parseThumbnailWidths(spec string) ([]int, error) {
+	widths := []int{}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		width, err := strconv.Atoi(field)
+		if err != nil || width <= 0 {
+			return nil, fmt.Errorf("invalid width %q", field)
+		}
+		widths = append(widths, width)
+	}
+	if len(widths) == 0 {
+		return nil, fmt.Errorf("at least one width is required")
+	}
+	sort.Ints(widths)
+	return widths, nil
+}
+
+// parseThumbnailFormats parses a comma separated list of extra encodings,
+// webp and avif
+func parseThumbnailFormats(spec string) ([]string, error) {
+	formats := []string{}
+	for _, field := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(field) {
+		case "":
+		case "webp":
+			formats = append(formats, ImageWebPType)
+		case "avif":
+			formats = append(formats, ImageAVIFType)
+		default:
+			return nil, fmt.Errorf("unknown thumbnail format %q", field)
+		}
+	}
+	return formats, nil
+}
+
+// variantWidths picks the widths to render for an image srcWidth wide,
+// images are never upscaled and the full width is kept when it falls
+// between two configured widths
+func variantWidths(srcWidth int, widths []int) []int {
+	variants := []int{}
+	for _, width := range widths {
+		if width < srcWidth {
+			variants = append(variants, width)
+		}
+	}
+	largest := widths[len(widths)-1]
+	if srcWidth <= largest {
+		variants = append(variants, srcWidth)
+	} else {
+		variants = append(variants, largest)
+	}
+	return variants
+}
+
+// renderThumbnail decodes an uploaded image, applies its EXIF orientation
+// and writes each variant to workDir. Re-encoding drops the EXIF and other
+// metadata of the upload. Extra formats are encoded with ffmpeg on a best
+// effort basis, a failure only loses that format.
+func renderThumbnail(data []byte, workDir string, opts thumbnailOptions) ([]thumbnailImage, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, NewValidationError("thumbnail", "couldn't decode image")
+	}
+	if config.Width*config.Height > MaxThumbnailPixels {
+		return nil, NewValidationError("thumbnail", "image dimensions are too large")
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, NewValidationError("thumbnail", "couldn't decode image")
+	}
+
+	// keep transparency, everything else is served as JPEG
+	fallbackType := ImageJPEGType
+	if opaque, ok := src.(interface{ Opaque() bool }); ok && !opaque.Opaque() {
+		fallbackType = ImagePNGType
+	}
+
+	// variants are scaled from the stored pixels and only then rotated, so
+	// the full size image is never copied. Orientations 5 to 8 swap width
+	// and height.
+	orientation := exifOrientation(data)
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	if orientation >= 5 {
+		srcWidth, srcHeight = srcHeight, srcWidth
+	}
+
+	images := []thumbnailImage{}
+	for _, width := range variantWidths(srcWidth, opts.Widths) {
+		height := max(1, srcHeight*width/srcWidth)
+		scaledRect := image.Rect(0, 0, width, height)
+		if orientation >= 5 {
+			scaledRect = image.Rect(0, 0, height, width)
+		}
+		scaled := image.NewNRGBA(scaledRect)
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), src, bounds, draw.Src, nil)
+		scaled = applyOrientation(scaled, orientation)
+
+		base := filepath.Join(workDir, strconv.Itoa(width))
+		fallbackPath := base + mediaTypeToExt(fallbackType)
+		if err := encodeImageFile(fallbackPath, scaled, fallbackType); err != nil {
+			return nil, NewFileProcessingError("encode", fmt.Sprintf("couldn't encode thumbnail: %v", err))
+		}
+		images = append(images, thumbnailImage{Width: width, MediaType: fallbackType, Path: fallbackPath})
+
+		if len(opts.Formats) == 0 {
+			continue
+		}
+		// ffmpeg gets a lossless copy so the extra formats aren't encoded
+		// from an already lossy JPEG
+		losslessPath := base + "-source" + PNGExtension
+		if err := encodeImageFile(losslessPath, scaled, ImagePNGType); err != nil {
+			return nil, NewFileProcessingError("encode", fmt.Sprintf("couldn't encode thumbnail: %v", err))
+		}
+		for _, mediaType := range opts.Formats {
+			outPath := base + mediaTypeToExt(mediaType)
+			if err := encodeWithFFmpeg(losslessPath, outPath, mediaType); err != nil {
+				log.Printf("Couldn't encode %dw thumbnail as %s: %v", width, mediaType, err)
+				continue
+			}
+			images = append(images, thumbnailImage{Width: width, MediaType: mediaType, Path: outPath})
+		}
+	}
+	return images, nil
+}
+
+func encodeImageFile(outPath string, img image.Image, mediaType string) error {
+	f, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if mediaType == ImagePNGType {
+		err = png.Encode(f, img)
+	} else {
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: ThumbnailJPEGQuality})
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// encodeWithFFmpeg converts a still image to WebP or AVIF
+func encodeWithFFmpeg(srcPath, outPath, mediaType string) error {
+	args := []string{"-i", srcPath, "-map_metadata", "-1"}
+	switch mediaType {
+	case ImageWebPType:
+		args = append(args, "-c:v", "libwebp", "-quality", "80")
+	case ImageAVIFType:
+		args = append(args, "-c:v", "libaom-av1", "-still-picture", "1", "-crf", "32", "-cpu-used", "6")
+	default:
+		return fmt.Errorf("unsupported format %s", mediaType)
+	}
+	return runFFmpeg(append(args, "-frames:v", "1", outPath)...)
+}
+
+// storeThumbnail normalises an uploaded image and stores its variants. It
+// returns the URL of the largest JPEG or PNG variant, the srcset of every
+// format and the keys of every stored variant.
+func (cfg *apiConfig) storeThumbnail(ctx context.Context, data []byte) (string, database.ThumbnailSrcset, []string, error) {
+	workDir, err := os.MkdirTemp("", "tubely-thumbnail-")
+	if err != nil {
+		return "", nil, nil, NewFileProcessingError("work_dir", "couldn't create thumbnail directory")
+	}
+	defer os.RemoveAll(workDir)
+
+	images, err := renderThumbnail(data, workDir, cfg.thumbnails)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	name := getRandomAssetsName(32)
+	keys := []string{}
+	mainURL := ""
+	srcset := database.ThumbnailSrcset{}
+	for _, img := range images {
+		key := fmt.Sprintf("%s-%dw%s", name, img.Width, mediaTypeToExt(img.MediaType))
+		if err := cfg.putThumbnailFile(ctx, key, img); err != nil {
+			cfg.deleteThumbnailKeys(ctx, keys)
+			return "", nil, nil, err
+		}
+		keys = append(keys, key)
+
+		url := cfg.assetStorage.URL(key)
+		entry := fmt.Sprintf("%s %dw", url, img.Width)
+		if srcset[img.MediaType] != "" {
+			entry = srcset[img.MediaType] + ", " + entry
+		}
+		srcset[img.MediaType] = entry
+		if img.MediaType == ImageJPEGType || img.MediaType == ImagePNGType {
+			mainURL = url
+		}
+	}
+	return mainURL, srcset, keys, nil
+}
+
+func (cfg *apiConfig) putThumbnailFile(ctx context.Context, key string, img thumbnailImage) error {
+	f, err := os.Open(img.Path)
+	if err != nil {
+		return NewFileProcessingError("open", "couldn't open thumbnail variant")
+	}
+	defer f.Close()
+
+	if err := cfg.assetStorage.Put(ctx, key, f, img.MediaType); err != nil {
+		return NewStorageError("upload", fmt.Sprintf("failed to upload thumbnail: %v", err))
+	}
+	return nil
+}
+
+// deleteThumbnailKeys removes stored variants that ended up unused
+func (cfg *apiConfig) deleteThumbnailKeys(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := cfg.assetStorage.Delete(ctx, key); err != nil {
+			log.Printf("Couldn't delete unused thumbnail %s: %v", key, err)
+		}
+	}
+}
+
+// exifOrientation returns the EXIF orientation of a JPEG, PNG or WebP
+// image, 1 (upright) when it has none
+func exifOrientation(data []byte) int {
+	var tiff []byte
+	switch sniffMediaType(data) {
+	case ImageJPEGType:
+		tiff = jpegEXIF(data)
+	case ImagePNGType:
+		tiff = pngEXIF(data)
+	case ImageWebPType:
+		tiff = webpEXIF(data)
+	}
+	if tiff == nil {
+		return 1
+	}
+	return tiffOrientation(tiff)
+}
+
+// jpegEXIF finds the TIFF data of the APP1 Exif segment
+func jpegEXIF(data []byte) []byte {
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		// start of scan, the metadata segments are all before it
+		if marker == 0xDA || marker == 0xD9 {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil
+		}
+		segment := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i = end
+	}
+	return nil
+}
+
+// pngEXIF finds the TIFF data of the eXIf chunk
+func pngEXIF(data []byte) []byte {
+	for i := 8; i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf":
+			return data[i+8 : i+8+length]
+		case "IDAT", "IEND":
+			return nil
+		}
+		i = end
+	}
+	return nil
+}
+
+// webpEXIF finds the TIFF data of the EXIF chunk
+func webpEXIF(data []byte) []byte {
+	for i := 12; i+8 <= len(data); {
+		length := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + length
+		if length < 0 || end > len(data) {
+			return nil
+		}
+		if string(data[i:i+4]) == "EXIF" {
+			return bytes.TrimPrefix(data[i+8:end], []byte("Exif\x00\x00"))
+		}
+		// chunks are padded to an even length
+		i = end + length%2
+	}
+	return nil
+}
+
+// tiffOrientation reads the Orientation tag of IFD0
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// applyOrientation flips and rotates img so that it displays upright for
+// an EXIF orientation of 2 to 8
+func applyOrientation(img *image.NRGBA, orientation int) *image.NRGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// orientations 5 to 8 swap width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], row[x*4:x*4+4])
+		}
+	}
+	return dst
+}
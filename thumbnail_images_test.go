@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// testTIFF builds the TIFF header and an IFD0 holding a Software tag and,
+// when orientation isn't 0, an Orientation tag after it
+func testTIFF(order binary.AppendByteOrder, orientation uint16) []byte {
+	tiff := []byte("II")
+	if order == binary.BigEndian {
+		tiff = []byte("MM")
+	}
+	tiff = order.AppendUint16(tiff, 42)
+	tiff = order.AppendUint32(tiff, 8)
+
+	entries := uint16(1)
+	if orientation != 0 {
+		entries = 2
+	}
+	tiff = order.AppendUint16(tiff, entries)
+	// Software, ASCII, 4 bytes stored inline
+	tiff = order.AppendUint16(tiff, 0x0131)
+	tiff = order.AppendUint16(tiff, 2)
+	tiff = order.AppendUint32(tiff, 4)
+	tiff = append(tiff, "abc\x00"...)
+	if orientation != 0 {
+		// Orientation, SHORT, 1 value padded to 4 bytes
+		tiff = order.AppendUint16(tiff, 0x0112)
+		tiff = order.AppendUint16(tiff, 3)
+		tiff = order.AppendUint32(tiff, 1)
+		tiff = order.AppendUint16(tiff, orientation)
+		tiff = order.AppendUint16(tiff, 0)
+	}
+	return order.AppendUint32(tiff, 0)
+}
+
+func TestTIFFOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		tiff []byte
+		want int
+	}{
+		{"little endian", testTIFF(binary.LittleEndian, 6), 6},
+		{"big endian", testTIFF(binary.BigEndian, 8), 8},
+		{"normal", testTIFF(binary.BigEndian, 1), 1},
+		{"no orientation tag", testTIFF(binary.LittleEndian, 0), 1},
+		{"out of range", testTIFF(binary.LittleEndian, 9), 1},
+		{"truncated IFD", testTIFF(binary.LittleEndian, 6)[:30], 1},
+		{"IFD past the end", append([]byte("II*\x00\xff\x00\x00\x00"), make([]byte, 8)...), 1},
+		{"IFD inside the header", []byte("MM\x00*\x00\x00\x00\x02\x00\x00"), 1},
+		{"unknown byte order", append([]byte("XX"), testTIFF(binary.LittleEndian, 6)[2:]...), 1},
+		{"short", []byte("II*\x00"), 1},
+		{"empty", nil, 1},
+	}
+	for _, tt := range tests {
+		if got := tiffOrientation(tt.tiff); got != tt.want {
+			t.Errorf("%s: tiffOrientation = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEXIFOrientation(t *testing.T) {
+	tiff := testTIFF(binary.BigEndian, 6)
+
+	jpeg := []byte{0xFF, 0xD8}
+	// a JFIF segment before the Exif one
+	jpeg = append(jpeg, 0xFF, 0xE0, 0x00, 0x07)
+	jpeg = append(jpeg, "JFIF\x00"...)
+	jpeg = append(jpeg, 0xFF, 0xE1)
+	jpeg = binary.BigEndian.AppendUint16(jpeg, uint16(2+6+len(tiff)))
+	jpeg = append(jpeg, "Exif\x00\x00"...)
+	jpeg = append(jpeg, tiff...)
+	jpeg = append(jpeg, 0xFF, 0xDA, 0x00, 0x02)
+
+	png := []byte("\x89PNG\r\n\x1a\n")
+	png = binary.BigEndian.AppendUint32(png, uint32(len(tiff)))
+	png = append(png, "eXIf"...)
+	png = append(png, tiff...)
+	png = append(png, 0, 0, 0, 0)
+
+	webp := []byte("RIFF\x00\x00\x00\x00WEBP")
+	webp = append(webp, "EXIF"...)
+	webp = binary.LittleEndian.AppendUint32(webp, uint32(6+len(tiff)))
+	webp = append(webp, "Exif\x00\x00"...)
+	webp = append(webp, tiff...)
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"jpeg", jpeg, 6},
+		{"jpeg after start of scan", append([]byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02}, jpeg[2:]...), 1},
+		{"jpeg truncated segment", jpeg[:len(jpeg)-10], 1},
+		{"png", png, 6},
+		{"png truncated chunk", png[:len(png)-8], 1},
+		{"webp", webp, 6},
+		{"webp truncated chunk", webp[:len(webp)-4], 1},
+		{"unknown", tiff, 1},
+	}
+	for _, tt := range tests {
+		if got := exifOrientation(tt.data); got != tt.want {
+			t.Errorf("%s: exifOrientation = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// The image is
+//
+//	a b c
+//	d e f
+//
+// and each orientation is the transform that displays it upright
+func TestApplyOrientation(t *testing.T) {
+	tests := []struct {
+		orientation int
+		want        string
+	}{
+		{0, "abc/def"},
+		{1, "abc/def"},
+		{2, "cba/fed"},
+		{3, "fed/cba"},
+		{4, "def/abc"},
+		{5, "ad/be/cf"},
+		{6, "da/eb/fc"},
+		{7, "fc/eb/da"},
+		{8, "cf/be/ad"},
+		{9, "abc/def"},
+	}
+	for _, tt := range tests {
+		if got := imageRows(applyOrientation(letterImage("abc/def"), tt.orientation)); got != tt.want {
+			t.Errorf("orientation %d = %s, want %s", tt.orientation, got, tt.want)
+		}
+	}
+
+	// a sub-image starts at its own bounds
+	sub := letterImage("xxxx/xabc/xdef").SubImage(image.Rect(1, 1, 4, 3)).(*image.NRGBA)
+	if got := imageRows(applyOrientation(sub, 6)); got != "da/eb/fc" {
+		t.Errorf("orientation 6 of a sub-image = %s, want da/eb/fc", got)
+	}
+}
+
+// letterImage draws rows of letters as pixels, the letter in the red channel
+func letterImage(rows string) *image.NRGBA {
+	lines := strings.Split(rows, "/")
+	img := image.NewNRGBA(image.Rect(0, 0, len(lines[0]), len(lines)))
+	for y, line := range lines {
+		for x := range len(line) {
+			img.SetNRGBA(x, y, color.NRGBA{R: line[x], A: 0xFF})
+		}
+	}
+	return img
+}
+
+func imageRows(img *image.NRGBA) string {
+	b := img.Bounds()
+	rows := []string{}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var row strings.Builder
+		for x := b.Min.X; x < b.Max.X; x++ {
+			row.WriteByte(img.NRGBAAt(x, y).R)
+		}
+		rows = append(rows, row.String())
+	}
+	return strings.Join(rows, "/")
+}
@@ -34,8 +34,9 @@ func ValidateVideoID(videoIDString string) ValidationResult {
 	return ValidationResult{IsValid: true, Error: nil}
 }
 
-// ValidateVideoFile validates if the uploaded file is a valid video
-func ValidateVideoFile(header *multipart.FileHeader) ValidationResult {
+// ValidateVideoFile validates if the uploaded file is a video in one of the
+// allowed formats
+func ValidateVideoFile(header *multipart.FileHeader, allowed map[string]videoInputFormat) ValidationResult {
 	if header == nil {
 		return ValidationResult{
 			IsValid: false,
@@ -51,10 +52,10 @@ func ValidateVideoFile(header *multipart.FileHeader) ValidationResult {
 		}
 	}
 
-	if mediaType != VideoMP4Type {
+	if _, ok := allowed[mediaType]; !ok {
 		return ValidationResult{
 			IsValid: false,
-			Error:   NewValidationError("video", "only MP4 videos are supported"),
+			Error:   NewValidationError("video", "unsupported video format"),
 		}
 	}
 
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// assetRef points at an object in one of the blob stores
+type assetRef struct {
+	Store string
+	Key   string
+	// Prefix refers to every object under Key, it stands in for a listing
+	// that failed
+	Prefix bool
+}
+
+// blobStore returns the store recorded under name in asset references
+func (cfg *apiConfig) blobStore(name string) (storage.BlobStore, bool) {
+	switch name {
+	case AssetStoreVideo:
+		return cfg.videoStorage, true
+	case AssetStoreThumbnail:
+		return cfg.assetStorage, true
+	default:
+		return nil, false
+	}
+}
+
+// keyFromPublicURL recovers the key of an object from its public URL
+func keyFromPublicURL(store storage.BlobStore, url string) (string, bool) {
+	base := store.URL("")
+	if !strings.HasPrefix(url, base) || len(url) == len(base) {
+		return "", false
+	}
+	return strings.TrimPrefix(url, base), true
+}
+
+// videoKey returns the storage key of the uploaded video file, accepting
+// both the "bucket,key" format and full URLs saved by older versions
+func (cfg *apiConfig) videoKey(video database.Video) (string, bool) {
+	if video.VideoURL == nil {
+		return "", false
+	}
+	if _, key, ok := parseVideoStorageRef(*video.VideoURL); ok {
+		return key, true
+	}
+	return keyFromPublicURL(cfg.videoStorage, *video.VideoURL)
+}
+
+// videoAssetRefs lists every stored object belonging to a video: the video
+// file, any renditions stored under its name, the streaming output under its
+// stream prefix and the thumbnail. A prefix that can't be listed is returned
+// as a prefix ref, together with the listing error.
+func (cfg *apiConfig) videoAssetRefs(ctx context.Context, video database.Video) ([]assetRef, error) {
+	refs := []assetRef{}
+	var errs []error
+	addPrefix := func(prefix string) {
+		objects, err := cfg.videoStorage.List(ctx, prefix)
+		if err != nil {
+			errs = append(errs, err)
+			refs = append(refs, assetRef{Store: AssetStoreVideo, Key: prefix, Prefix: true})
+			return
+		}
+		for _, obj := range objects {
+			refs = append(refs, assetRef{Store: AssetStoreVideo, Key: obj.Key})
+		}
+	}
+
+	if key, ok := cfg.videoKey(video); ok {
+		refs = append(refs, assetRef{Store: AssetStoreVideo, Key: key})
+		addPrefix(strings.TrimSuffix(key, path.Ext(key)) + "/")
+	}
+
+	addPrefix(streamKeyPrefix(video.ID))
+
+	for _, key := range cfg.thumbnailKeys(video) {
+		refs = append(refs, assetRef{Store: AssetStoreThumbnail, Key: key})
+	}
+
+	return refs, errors.Join(errs...)
+}
+
+// thumbnailKeys returns the keys of a video's thumbnail and every variant
+// listed in its srcset
+func (cfg *apiConfig) thumbnailKeys(video database.Video) []string {
+	seen := map[string]bool{}
+	keys := []string{}
+	add := func(url string) {
+		if key, ok := keyFromPublicURL(cfg.assetStorage, url); ok && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	if video.ThumbnailURL != nil {
+		add(*video.ThumbnailURL)
+	}
+	for _, srcset := range video.ThumbnailSrcset {
+		for _, candidate := range strings.Split(srcset, ",") {
+			if fields := strings.Fields(candidate); len(fields) > 0 {
+				add(fields[0])
+			}
+		}
+	}
+	return keys
+}
+
+// deleteVideoAssets removes the stored objects of a deleted video. Objects
+// that can't be removed are recorded and retried in the background.
+func (cfg *apiConfig) deleteVideoAssets(ctx context.Context, video database.Video) {
+	refs, err := cfg.videoAssetRefs(ctx, video)
+	if err != nil {
+		log.Printf("Couldn't list all assets of video %s: %v", video.ID, err)
+	}
+
+	for _, ref := range refs {
+		err := cfg.deleteAssetRef(ctx, ref)
+		if err == nil {
+			continue
+		}
+		log.Printf("Couldn't delete %s asset %s, will retry: %v", ref.Store, ref.Key, err)
+		err = cfg.db.CreateAssetDeletion(database.CreateAssetDeletionParams{
+			Store:  ref.Store,
+			Key:    ref.Key,
+			Prefix: ref.Prefix,
+		}, err)
+		if err != nil {
+			log.Printf("Couldn't record failed deletion of %s: %v", ref.Key, err)
+		}
+	}
+}
+
+// deleteAssetRef deletes the object ref points at, or every object under it
+// for a prefix ref
+func (cfg *apiConfig) deleteAssetRef(ctx context.Context, ref assetRef) error {
+	store, ok := cfg.blobStore(ref.Store)
+	if !ok {
+		return fmt.Errorf("unknown store %q", ref.Store)
+	}
+	if !ref.Prefix {
+		return store.Delete(ctx, ref.Key)
+	}
+
+	objects, err := store.List(ctx, ref.Key)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, obj := range objects {
+		if err := store.Delete(ctx, obj.Key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// retryAssetDeletions makes one pass over the pending asset deletions
+func (cfg *apiConfig) retryAssetDeletions(ctx context.Context) {
+	deletions, err := cfg.db.GetPendingAssetDeletions(cfg.assetDeleteMaxAttempts, 100)
+	if err != nil {
+		log.Printf("Couldn't get pending asset deletions: %v", err)
+		return
+	}
+
+	for _, deletion := range deletions {
+		ref := assetRef{Store: deletion.Store, Key: deletion.Key, Prefix: deletion.Prefix}
+		if err := cfg.deleteAssetRef(ctx, ref); err != nil {
+			if err := cfg.db.RecordAssetDeletionFailure(deletion.ID, err); err != nil {
+				log.Printf("Couldn't record failed deletion of %s: %v", deletion.Key, err)
+			}
+			continue
+		}
+
+		if err := cfg.db.DeleteAssetDeletion(deletion.ID); err != nil {
+			log.Printf("Couldn't clear asset deletion %s: %v", deletion.ID, err)
+		}
+	}
+}
+
+// runAssetDeletionRetries retries failed deletions every interval until ctx
+// is done
+func (cfg *apiConfig) runAssetDeletionRetries(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cfg.retryAssetDeletions(ctx)
+		}
+	}
+}
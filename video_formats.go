@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// videoInputFormat is a container accepted for upload
+type videoInputFormat struct {
+	Name       string
+	MediaTypes []string
+	Extension  string
+	// Demuxer is the ffprobe format name that opens the container
+	Demuxer string
+}
+
+// videoInputFormats are the containers that can be enabled with
+// VIDEO_INPUT_FORMATS, everything but MP4 is converted to MP4 before storage
+var videoInputFormats = []videoInputFormat{
+	{Name: "mp4", MediaTypes: []string{VideoMP4Type}, Extension: MP4Extension, Demuxer: "mov"},
+	{Name: "mov", MediaTypes: []string{"video/quicktime"}, Extension: ".mov", Demuxer: "mov"},
+	{Name: "webm", MediaTypes: []string{"video/webm"}, Extension: ".webm", Demuxer: "matroska"},
+	{Name: "mkv", MediaTypes: []string{"video/x-matroska", "video/matroska"}, Extension: ".mkv", Demuxer: "matroska"},
+	{Name: "avi", MediaTypes: []string{"video/x-msvideo", "video/avi", "video/msvideo"}, Extension: ".avi", Demuxer: "avi"},
+}
+
+// parseVideoInputFormats parses a comma separated allow-list of container
+// names into a lookup by media type
+func parseVideoInputFormats(spec string) (map[string]videoInputFormat, error) {
+	byName := map[string]videoInputFormat{}
+	for _, format := range videoInputFormats {
+		byName[format.Name] = format
+	}
+
+	allowed := map[string]videoInputFormat{}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field == "" {
+			continue
+		}
+		format, ok := byName[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown video format %q", field)
+		}
+		for _, mediaType := range format.MediaTypes {
+			allowed[mediaType] = format
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("at least one video format must be allowed")
+	}
+	return allowed, nil
+}
+
+// knownVideoInputFormat looks up any supported media type, allowed or not
+func knownVideoInputFormat(mediaType string) (videoInputFormat, bool) {
+	for _, format := range videoInputFormats {
+		for _, t := range format.MediaTypes {
+			if t == mediaType {
+				return format, true
+			}
+		}
+	}
+	return videoInputFormat{}, false
+}
+
+// videoInputFormat looks up an allowed upload media type
+func (cfg *apiConfig) videoInputFormat(mediaType string) (videoInputFormat, bool) {
+	format, ok := cfg.videoFormats[mediaType]
+	return format, ok
+}
+
+// unsupportedVideoTypeError lists the allowed containers
+func (cfg *apiConfig) unsupportedVideoTypeError() ValidationError {
+	names := []string{}
+	for _, format := range videoInputFormats {
+		if _, ok := cfg.videoFormats[format.MediaTypes[0]]; ok {
+			names = append(names, strings.ToUpper(format.Name))
+		}
+	}
+	return NewValidationError("video", "supported video formats are "+strings.Join(names, ", "))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/google/uuid"
+)
+
+// processVideoPayload describes where a queued upload can be found, either
+// a spooled file on disk or a staged object in video storage
+type processVideoPayload struct {
+	VideoID    uuid.UUID `json:"video_id"`
+	SourcePath string    `json:"source_path,omitempty"`
+	SourceKey  string    `json:"source_key,omitempty"`
+	MediaType  string    `json:"media_type"`
+}
+
+// VideoJobResponse is returned when an upload has been queued for processing
+type VideoJobResponse struct {
+	JobID   uuid.UUID          `json:"job_id"`
+	VideoID uuid.UUID          `json:"video_id"`
+	Status  database.JobStatus `json:"status"`
+	Message string             `json:"message"`
+}
+
+func newVideoJobResponse(job database.Job) VideoJobResponse {
+	resp := VideoJobResponse{
+		JobID:   job.ID,
+		Status:  job.Status,
+		Message: "Video queued for processing",
+	}
+	if job.VideoID != nil {
+		resp.VideoID = *job.VideoID
+	}
+	return resp
+}
+
+// enqueueVideoProcessing queues the processing of an uploaded video
+func (cfg *apiConfig) enqueueVideoProcessing(userID uuid.UUID, payload processVideoPayload) (database.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return database.Job{}, err
+	}
+	return cfg.jobs.Enqueue(database.CreateJobParams{
+		Type:        JobTypeProcessVideo,
+		Payload:     string(data),
+		MaxAttempts: cfg.jobMaxAttempts,
+		UserID:      userID,
+		VideoID:     &payload.VideoID,
+	})
+}
+
+// processVideoJob runs the fast start, upload and database steps for a
+// queued upload. The source is kept until the job succeeds or runs out of
+// attempts so retries can start over.
+func (cfg *apiConfig) processVideoJob(ctx context.Context, job database.Job) error {
+	var payload processVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	video, err := cfg.db.GetVideo(payload.VideoID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return err
+	}
+	if err != nil || video.UserID != job.UserID {
+		// the video was deleted while the job was queued
+		cfg.removeVideoJobSource(payload)
+		return nil
+	}
+	cfg.setVideoStatus(video.ID, database.VideoStatusProcessing)
+
+	err = cfg.storeQueuedVideo(ctx, &video, payload)
+	if errors.Is(err, database.ErrNotFound) {
+		// the video was deleted while it was processed
+		cfg.removeVideoJobSource(payload)
+		return nil
+	}
+	if err != nil {
+		if job.Attempts >= job.MaxAttempts || jobs.IsPermanent(err) {
+			cfg.failVideo(video.ID, err)
+			cfg.removeVideoJobSource(payload)
+		}
+		return err
+	}
+
+	cfg.removeVideoJobSource(payload)
+	return nil
+}
+
+func (cfg *apiConfig) storeQueuedVideo(ctx context.Context, video *database.Video, payload processVideoPayload) error {
+	srcPath := payload.SourcePath
+	if payload.SourceKey != "" {
+		tmpPath, err := cfg.downloadVideoToTemp(ctx, payload.SourceKey)
+		if err != nil {
+			return NewStorageError("download", "couldn't read uploaded video")
+		}
+		defer os.Remove(tmpPath)
+		srcPath = tmpPath
+	}
+
+	_, err := cfg.storeVideoFromPath(ctx, video, srcPath, payload.MediaType)
+	return err
+}
+
+// removeVideoJobSource deletes the spooled file or staged object of a job
+func (cfg *apiConfig) removeVideoJobSource(payload processVideoPayload) {
+	if payload.SourcePath != "" {
+		if err := os.Remove(payload.SourcePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Couldn't remove spooled upload %s: %v", payload.SourcePath, err)
+		}
+	}
+	if payload.SourceKey != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := cfg.videoStorage.Delete(ctx, payload.SourceKey); err != nil {
+			log.Printf("Couldn't delete staged upload %s: %v", payload.SourceKey, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// previewOptions configures the scrubbing preview sprite sheet
+type previewOptions struct {
+	// Interval between frames, zero disables previews
+	Interval time.Duration
+	// Width of a single frame in the sheet
+	Width int
+}
+
+// previewOutputs are the keys of a video's preview sprite sheet and WebVTT
+// track, both empty when no previews were made
+type previewOutputs struct {
+	SpriteKey string
+	VTTKey    string
+}
+
+// previewLayout is the grid of frames in a sprite sheet
+type previewLayout struct {
+	Interval    float64 // seconds
+	Frames      int
+	Columns     int
+	Rows        int
+	FrameWidth  int
+	FrameHeight int
+}
+
+// newPreviewLayout fits the frames of a video into a single sheet of at most
+// PreviewMaxFrames frames, stretching the interval for long videos
+func newPreviewLayout(probe videoProbe, opts previewOptions) (previewLayout, error) {
+	if probe.Duration <= 0 || probe.Width <= 0 {
+		return previewLayout{}, fmt.Errorf("unknown video duration or size")
+	}
+
+	interval := math.Max(opts.Interval.Seconds(), probe.Duration/PreviewMaxFrames)
+	frames := int(math.Ceil(probe.Duration / interval))
+	columns := min(frames, PreviewColumns)
+	// even heights keep the scaler happy
+	height := int(math.Round(float64(opts.Width)*float64(probe.Height)/float64(probe.Width)/2)) * 2
+
+	return previewLayout{
+		Interval:    interval,
+		Frames:      frames,
+		Columns:     columns,
+		Rows:        (frames + columns - 1) / columns,
+		FrameWidth:  opts.Width,
+		FrameHeight: max(height, 2),
+	}, nil
+}
+
+// writePreviewVTT writes a WebVTT track mapping each interval of the video
+// to its frame in the sprite sheet, using media fragment coordinates. Cues
+// name the sheet relative to the track, handlerVideoPreviewTrack swaps in
+// its signed URL when serving it.
+func writePreviewVTT(outPath, spriteName string, layout previewLayout, duration float64) error {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i := 0; i < layout.Frames; i++ {
+		start := float64(i) * layout.Interval
+		end := math.Min(start+layout.Interval, duration)
+		x := (i % layout.Columns) * layout.FrameWidth
+		y := (i / layout.Columns) * layout.FrameHeight
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), spriteName, x, y, layout.FrameWidth, layout.FrameHeight)
+	}
+	return os.WriteFile(outPath, []byte(b.String()), 0o644)
+}
+
+func vttTimestamp(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	s := (d % time.Minute) / time.Second
+	ms := (d % time.Second) / time.Millisecond
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, ms)
+}
+
+// previewKeyPrefix is where the previews of a video file are stored, under
+// the name of the file like its other renditions
+func previewKeyPrefix(videoKey string) string {
+	return strings.TrimSuffix(videoKey, path.Ext(videoKey)) + "/" + PreviewDir + "/"
+}
+
+// storePreviews renders the sprite sheet and WebVTT track of srcPath,
+// described by probe, and stores them next to the video file. Failures are
+// logged, previews are optional and don't fail the upload.
+func (cfg *apiConfig) storePreviews(ctx context.Context, srcPath string, probe videoProbe, videoKey string) previewOutputs {
+	if cfg.previews.Interval <= 0 {
+		return previewOutputs{}
+	}
+
+	outputs, err := cfg.renderPreviews(ctx, srcPath, probe, videoKey)
+	if err != nil {
+		log.Printf("Couldn't create previews for %s: %v", videoKey, err)
+		return previewOutputs{}
+	}
+	return outputs
+}
+
+func (cfg *apiConfig) renderPreviews(ctx context.Context, srcPath string, probe videoProbe, videoKey string) (previewOutputs, error) {
+	layout, err := newPreviewLayout(probe, cfg.previews)
+	if err != nil {
+		return previewOutputs{}, err
+	}
+
+	outDir, err := os.MkdirTemp("", "tubely-previews-")
+	if err != nil {
+		return previewOutputs{}, err
+	}
+	defer os.RemoveAll(outDir)
+
+	spritePath := filepath.Join(outDir, PreviewSpriteName)
+	filter := fmt.Sprintf("fps=1/%.3f,scale=%d:%d,tile=%dx%d",
+		layout.Interval, layout.FrameWidth, layout.FrameHeight, layout.Columns, layout.Rows)
+	err = runFFmpeg("-i", srcPath, "-vf", filter, "-frames:v", "1", "-q:v", "4", "-y", spritePath)
+	if err != nil {
+		return previewOutputs{}, err
+	}
+
+	err = writePreviewVTT(filepath.Join(outDir, PreviewVTTName), PreviewSpriteName, layout, probe.Duration)
+	if err != nil {
+		return previewOutputs{}, err
+	}
+
+	prefix := previewKeyPrefix(videoKey)
+	if err := cfg.uploadDir(ctx, outDir, prefix, map[string]bool{}); err != nil {
+		return previewOutputs{}, err
+	}
+	return previewOutputs{
+		SpriteKey: prefix + PreviewSpriteName,
+		VTTKey:    prefix + PreviewVTTName,
+	}, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// setVideoStatus records a status transition. Errors are only logged so
+// they don't mask the outcome of the step that triggered the transition.
+func (cfg *apiConfig) setVideoStatus(videoID uuid.UUID, status database.VideoStatus) {
+	if err := cfg.db.UpdateVideoStatus(videoID, status, ""); err != nil {
+		log.Printf("Couldn't set status of video %s to %s: %v", videoID, status, err)
+	}
+}
+
+// failVideo marks the video as failed with err as the reason. A video that
+// already has a file keeps serving it, so it stays ready and only records
+// why the new upload didn't replace it.
+func (cfg *apiConfig) failVideo(videoID uuid.UUID, cause error) {
+	status := database.VideoStatusFailed
+	if video, err := cfg.db.GetVideo(videoID); err == nil && video.VideoURL != nil {
+		status = database.VideoStatusReady
+	}
+	if err := cfg.db.UpdateVideoStatus(videoID, status, cause.Error()); err != nil {
+		log.Printf("Couldn't mark video %s as failed: %v", videoID, err)
+	}
+}
+
+// restoreVideoStatus undoes the uploading status of an upload that was
+// cancelled or abandoned: videos with a file go back to ready, others to
+// draft
+func (cfg *apiConfig) restoreVideoStatus(videoID uuid.UUID) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		log.Printf("Couldn't restore status of video %s: %v", videoID, err)
+		return
+	}
+	cfg.setVideoStatus(videoID, restingVideoStatus(video))
+}
+
+func restingVideoStatus(video database.Video) database.VideoStatus {
+	if video.VideoURL != nil {
+		return database.VideoStatusReady
+	}
+	return database.VideoStatusDraft
+}
+
+// restoreAbandonedUploads restores the status of videos left uploading
+// longer than olderThan, which happens when a presigned upload is never
+// completed. Videos with a pending tus upload are left alone, those can be
+// resumed at any time.
+func (cfg *apiConfig) restoreAbandonedUploads(olderThan time.Duration) {
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		log.Printf("Couldn't get videos to restore abandoned uploads: %v", err)
+		return
+	}
+	uploads, err := cfg.tusStore.List()
+	if err != nil {
+		log.Printf("Couldn't list tus uploads: %v", err)
+		return
+	}
+	resumable := map[uuid.UUID]bool{}
+	for _, upload := range uploads {
+		resumable[upload.VideoID] = true
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	for _, video := range videos {
+		if video.Status != database.VideoStatusUploading || resumable[video.ID] || video.UpdatedAt.After(cutoff) {
+			continue
+		}
+		log.Printf("Restoring status of video %s, its upload was abandoned", video.ID)
+		cfg.setVideoStatus(video.ID, restingVideoStatus(video))
+	}
+}
+
+// runAbandonedUploadSweeps restores abandoned uploads every interval until
+// ctx is done. Presigned URLs are dead after presignUploadTTL, the grace
+// period leaves time to call the complete endpoint.
+func (cfg *apiConfig) runAbandonedUploadSweeps(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cfg.restoreAbandonedUploads(cfg.presignUploadTTL + AbandonedUploadGrace)
+		}
+	}
+}
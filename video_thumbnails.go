@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Automatic thumbnail modes
+const (
+	AutoThumbnailOff       = "off"
+	AutoThumbnailTimestamp = "timestamp"
+	AutoThumbnailBest      = "best"
+)
+
+// autoThumbnailOptions configures thumbnail extraction for videos uploaded
+// without one
+type autoThumbnailOptions struct {
+	Mode string
+	// At is the position of the frame grabbed in timestamp mode
+	At time.Duration
+}
+
+// extractThumbnail writes a JPEG frame of srcPath to outPath. Timestamp mode
+// falls back to the first frame for videos shorter than opts.At, best mode
+// lets ffmpeg's thumbnail filter pick the most representative frame of the
+// opening batch of frames.
+func extractThumbnail(srcPath, outPath string, opts autoThumbnailOptions) error {
+	var args []string
+	switch opts.Mode {
+	case AutoThumbnailTimestamp:
+		args = []string{"-ss", strconv.FormatFloat(opts.At.Seconds(), 'f', 3, 64), "-i", srcPath}
+	case AutoThumbnailBest:
+		args = []string{"-i", srcPath, "-vf", "thumbnail=" + strconv.Itoa(AutoThumbnailBatchFrames)}
+	default:
+		return fmt.Errorf("unknown thumbnail mode %q", opts.Mode)
+	}
+	args = append(args, "-frames:v", "1", "-q:v", "2", "-y", outPath)
+
+	if err := runFFmpeg(args...); err != nil {
+		return err
+	}
+	if info, err := os.Stat(outPath); err == nil && info.Size() > 0 {
+		return nil
+	}
+
+	if opts.Mode == AutoThumbnailTimestamp && opts.At > 0 {
+		// seeking past the end yields no frame
+		return extractThumbnail(srcPath, outPath, autoThumbnailOptions{Mode: AutoThumbnailTimestamp})
+	}
+	return fmt.Errorf("ffmpeg produced no frame")
+}
+
+// ensureVideoThumbnail gives a processed video a thumbnail taken from
+// srcPath if it has none. The thumbnail is stored like an uploaded one and
+// is only set while the video still has no thumbnail, so one uploaded by the
+// user in the meantime wins. Failures are logged, a missing thumbnail
+// doesn't fail the upload.
+func (cfg *apiConfig) ensureVideoThumbnail(ctx context.Context, video *database.Video, srcPath string) {
+	if cfg.autoThumbnail.Mode == AutoThumbnailOff || video.ThumbnailURL != nil {
+		return
+	}
+
+	tmpFile, err := os.CreateTemp("", "tubely-thumbnail-*"+JPEGExtension)
+	if err != nil {
+		log.Printf("Couldn't create thumbnail file for video %s: %v", video.ID, err)
+		return
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	if err := extractThumbnail(srcPath, tmpFile.Name(), cfg.autoThumbnail); err != nil {
+		log.Printf("Couldn't extract thumbnail of video %s: %v", video.ID, err)
+		return
+	}
+
+	data, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		log.Printf("Couldn't read thumbnail of video %s: %v", video.ID, err)
+		return
+	}
+
+	url, srcset, keys, err := cfg.storeThumbnail(ctx, data)
+	if err != nil {
+		log.Printf("Couldn't store thumbnail of video %s: %v", video.ID, err)
+		return
+	}
+
+	set, err := cfg.db.SetVideoThumbnailIfMissing(video.ID, url, srcset)
+	if err != nil || !set {
+		if err != nil {
+			log.Printf("Couldn't set thumbnail of video %s: %v", video.ID, err)
+		}
+		cfg.deleteThumbnailKeys(ctx, keys)
+		return
+	}
+	video.ThumbnailURL = &url
+	video.ThumbnailSrcset = srcset
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// urlSigningOptions are the per request restrictions applied to signed
+// video URLs, set with the expires_in, ip and scope query parameters
+type urlSigningOptions struct {
+	TTL      time.Duration
+	SourceIP string
+	// Prefix signs a wildcard covering every object next to the video
+	// (renditions, segments) instead of the video file alone
+	Prefix bool
+}
+
+func (cfg *apiConfig) defaultURLSigningOptions() urlSigningOptions {
+	return urlSigningOptions{TTL: cfg.presignGetTTL}
+}
+
+// parseURLSigningOptions reads signing restrictions from the query string.
+// ip may be an address, a CIDR or "client" for the caller's own address.
+func (cfg *apiConfig) parseURLSigningOptions(r *http.Request) (urlSigningOptions, error) {
+	opts := cfg.defaultURLSigningOptions()
+	query := r.URL.Query()
+
+	if expiresIn := query.Get("expires_in"); expiresIn != "" {
+		ttl, err := time.ParseDuration(expiresIn)
+		if err != nil || ttl <= 0 {
+			return opts, NewValidationError("expires_in", "must be a positive duration such as 10m")
+		}
+		if ttl > cfg.signedURLMaxTTL {
+			return opts, NewValidationError("expires_in", fmt.Sprintf("must not exceed %s", cfg.signedURLMaxTTL))
+		}
+		opts.TTL = ttl
+	}
+
+	if ip := query.Get("ip"); ip != "" {
+		if ip == "client" {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				return opts, NewValidationError("ip", "couldn't determine client address")
+			}
+			ip = host
+		}
+		cidr, err := normalizeCIDR(ip)
+		if err != nil {
+			return opts, NewValidationError("ip", "must be an IP address or CIDR")
+		}
+		opts.SourceIP = cidr
+	}
+
+	switch query.Get("scope") {
+	case "", "file":
+	case "prefix":
+		opts.Prefix = true
+	default:
+		return opts, NewValidationError("scope", "must be file or prefix")
+	}
+
+	return opts, nil
+}
+
+// signingOptionsRequested reports whether the request sets any of the
+// options parseURLSigningOptions reads
+func signingOptionsRequested(r *http.Request) bool {
+	query := r.URL.Query()
+	return query.Has("expires_in") || query.Has("ip") || query.Has("scope")
+}
+
+func normalizeCIDR(value string) (string, error) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return "", err
+		}
+		return network.String(), nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP address %q", value)
+	}
+	if ip.To4() != nil {
+		return ip.String() + "/32", nil
+	}
+	return ip.String() + "/128", nil
+}
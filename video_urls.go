@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// videoBucket is the bucket name recorded with stored video keys. Backends
+// without buckets use their own name so the "bucket,key" format still holds.
+func (cfg *apiConfig) videoBucket() string {
+	if cfg.storageBackend == StorageBackendS3 {
+		return cfg.s3Bucket
+	}
+	return cfg.storageBackend
+}
+
+// videoStorageRef builds the "bucket,key" value stored in videos.video_url
+func (cfg *apiConfig) videoStorageRef(key string) string {
+	return fmt.Sprintf("%s,%s", cfg.videoBucket(), key)
+}
+
+// optionalVideoStorageRef is videoStorageRef for keys that may be empty
+func (cfg *apiConfig) optionalVideoStorageRef(key string) *string {
+	if key == "" {
+		return nil
+	}
+	ref := cfg.videoStorageRef(key)
+	return &ref
+}
+
+// parseVideoStorageRef splits a stored "bucket,key" value. ok is false for
+// values in any other format, such as full URLs saved by older versions.
+func parseVideoStorageRef(ref string) (bucket, key string, ok bool) {
+	if !ValidateVideoURL(ref).IsValid || ref == "" {
+		return "", "", false
+	}
+	bucket, key, _ = strings.Cut(ref, ",")
+	if strings.Contains(bucket, "/") {
+		return "", "", false
+	}
+	return bucket, key, true
+}
+
+// presignVideoKey returns a short lived URL for a key in video storage.
+// With a CloudFront key pair configured the URL is a CloudFront signed URL
+// carrying the IP and path restrictions from opts, otherwise it is a
+// presigned storage URL that only honours the TTL.
+func (cfg *apiConfig) presignVideoKey(ctx context.Context, key string, opts urlSigningOptions) (string, error) {
+	if cfg.cfSigner != nil {
+		restrictions := cdn.Restrictions{
+			Expires:  time.Now().Add(opts.TTL),
+			SourceIP: opts.SourceIP,
+		}
+		if opts.Prefix {
+			restrictions.Resource = cfg.videoPrefixResource(key)
+		}
+		url, err := cfg.cfSigner.SignURL(cfg.videoStorage.URL(key), restrictions)
+		if err != nil {
+			return "", NewStorageError("sign", fmt.Sprintf("couldn't sign %s: %v", key, err))
+		}
+		return url, nil
+	}
+
+	url, err := cfg.videoStorage.PresignGet(ctx, key, opts.TTL)
+	if err != nil {
+		return "", NewStorageError("presign", fmt.Sprintf("couldn't presign %s: %v", key, err))
+	}
+	return url, nil
+}
+
+// videoPrefixResource is the wildcard resource covering a video file and
+// every object stored under its name, e.g. https://cdn/landscape/abc*. A
+// streaming manifest covers its whole directory so variants and segments
+// are included.
+func (cfg *apiConfig) videoPrefixResource(key string) string {
+	if ext := path.Ext(key); ext == ".m3u8" || ext == ".mpd" {
+		return cfg.videoStorage.URL(path.Dir(key)) + "/*"
+	}
+	return cfg.videoStorage.URL(strings.TrimSuffix(key, path.Ext(key))) + "*"
+}
+
+// dbVideoToSignedVideo replaces the stored bucket and key of a video, its
+// archived original, streaming manifests and previews with URLs the client
+// can use. The database value is never modified.
+func (cfg *apiConfig) dbVideoToSignedVideo(ctx context.Context, video database.Video, opts urlSigningOptions) (database.Video, error) {
+	url, err := cfg.signVideoRef(ctx, video.VideoURL, opts)
+	if err != nil {
+		return video, err
+	}
+	video.VideoURL = url
+
+	video.OriginalURL, err = cfg.signVideoRef(ctx, video.OriginalURL, opts)
+	if err != nil {
+		return video, err
+	}
+
+	video.HLSURL = cfg.streamManifestURL(video.HLSURL)
+	video.DASHURL = cfg.streamManifestURL(video.DASHURL)
+
+	video.PreviewSpriteURL, err = cfg.signVideoRef(ctx, video.PreviewSpriteURL, opts)
+	if err != nil {
+		return video, err
+	}
+	// the stored track names the sheet relative to itself, which players
+	// resolve against the track URL without a signature, so it is served
+	// through the API with the sheet's signed URL in every cue
+	if video.PreviewVTTURL != nil && video.PreviewSpriteURL != nil {
+		track := fmt.Sprintf("/api/videos/%s/previews.vtt", video.ID)
+		video.PreviewVTTURL = &track
+	} else {
+		video.PreviewVTTURL = nil
+	}
+	return video, nil
+}
+
+// streamManifestURL returns the URL a player loads a stored streaming
+// manifest from. Players fetch the variant playlists and segments relative
+// to the manifest without its query string, so a signature on the manifest
+// URL never reaches them. With CloudFront the URL is left unsigned and the
+// player needs the cookies from /api/videos/{videoID}/cookies, which cover
+// the whole stream prefix. A private bucket without CloudFront can't serve
+// streams and nil is returned. The local and memory stores serve objects
+// without signatures.
+func (cfg *apiConfig) streamManifestURL(ref *string) *string {
+	if ref == nil {
+		return nil
+	}
+
+	bucket, key, ok := parseVideoStorageRef(*ref)
+	if !ok {
+		return ref
+	}
+	if bucket != cfg.videoBucket() {
+		log.Printf("Not returning %s, it is stored in unknown bucket %q", *ref, bucket)
+		return nil
+	}
+	if cfg.cfSigner == nil && cfg.storageBackend == StorageBackendS3 {
+		return nil
+	}
+	url := cfg.videoStorage.URL(key)
+	return &url
+}
+
+// signVideoRef signs a stored "bucket,key" reference, other values are
+// returned unchanged. References to a bucket the server no longer uses
+// can't be signed, they are logged and returned as nil so one stale row
+// doesn't fail a whole listing.
+func (cfg *apiConfig) signVideoRef(ctx context.Context, ref *string, opts urlSigningOptions) (*string, error) {
+	if ref == nil {
+		return nil, nil
+	}
+
+	bucket, key, ok := parseVideoStorageRef(*ref)
+	if !ok {
+		return ref, nil
+	}
+	if bucket != cfg.videoBucket() {
+		log.Printf("Not signing %s, it is stored in unknown bucket %q", *ref, bucket)
+		return nil, nil
+	}
+
+	url, err := cfg.presignVideoKey(ctx, key, opts)
+	if err != nil {
+		return ref, err
+	}
+	return &url, nil
+}